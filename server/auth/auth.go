@@ -22,7 +22,7 @@ const (
 )
 
 type Claims struct {
-	UserID   uint8       `json:"userId"`
+	UserID   uint32      `json:"userId"`
 	Username string      `json:"username"`
 	Role     common.Role `json:"role"`
 	RoomID   string      `json:"roomId"`
@@ -32,6 +32,8 @@ type Claims struct {
 type InviteClaims struct {
 	RoomID    string `json:"roomId"`
 	SecretKey string `json:"secretKey"`
+	InviteID  string `json:"inviteId,omitempty"`
+	Spectator bool   `json:"spectator,omitempty"`
 	Exp       int64  `json:"exp"`
 }
 
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
+const (
+	passwordSaltLength = 16
+	passwordKeyLength  = 32
+	passwordIterations = 600_000
+)
+
+// HashPassword derives a salted PBKDF2-SHA256 key from the password, the salt is prepended to the key
+func HashPassword(password string) ([]byte, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeyLength)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(salt, key...), nil
+}
+
+// VerifyPassword compares the password against a hash made by HashPassword in constant time
+func VerifyPassword(hash []byte, password string) bool {
+	if len(hash) != passwordSaltLength+passwordKeyLength {
+		return false
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, hash[:passwordSaltLength], passwordIterations, passwordKeyLength)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(key, hash[passwordSaltLength:]) == 1
+}
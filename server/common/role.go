@@ -3,6 +3,52 @@ package common
 type Role uint8
 
 const (
-	Admin  Role = 1
-	Member Role = 2
+	Admin     Role = 1
+	Member    Role = 2
+	Moderator Role = 3
+	// spectators can follow the chat but can't post
+	Spectator Role = 4
 )
+
+type Permission uint16
+
+const (
+	PermKick Permission = 1 << iota
+	PermBan
+	PermInvite
+	PermClose
+	PermExtend
+	PermMute
+	PermManageRoles
+	PermTransfer
+	PermPassword
+	PermLock
+	PermSlowMode
+	PermRename
+	PermPost
+	// rotating the invite link also revokes every invite minted for the room
+	PermRotateInvite
+)
+
+var rolePermissions = map[Role]Permission{
+	Admin:     PermKick | PermBan | PermInvite | PermClose | PermExtend | PermMute | PermManageRoles | PermTransfer | PermPassword | PermLock | PermSlowMode | PermRename | PermPost | PermRotateInvite,
+	Moderator: PermKick | PermBan | PermInvite | PermMute | PermPost,
+	Member:    PermPost,
+	Spectator: 0,
+}
+
+// higher rank can act on participants with a lower rank (kick, ban, change role...)
+var roleRanks = map[Role]uint8{
+	Admin:     3,
+	Moderator: 2,
+	Member:    1,
+	Spectator: 0,
+}
+
+func (r Role) Can(p Permission) bool {
+	return rolePermissions[r]&p == p
+}
+
+func (r Role) Outranks(other Role) bool {
+	return roleRanks[r] > roleRanks[other]
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 // Injected during the build for prod, taken from env variables for development
@@ -11,6 +13,49 @@ var (
 	APIKey    string
 )
 
+// Room limits, defaults can be overridden with env variables
+var (
+	RoomLifetime    = 30 * time.Minute
+	MinRoomLifetime = 5 * time.Minute
+	MaxRoomLifetime = 2 * time.Hour
+	RoomExtension   = 30 * time.Minute
+
+	MaxRoomParticipants uint16 = 5
+	// spectators don't take up participant slots, they have their own limit per room
+	MaxRoomSpectators uint16 = 5
+
+	// how long a join request waits for the admin answer in rooms with knock mode
+	KnockTimeout = 2 * time.Minute
+
+	// joiners that can queue for a full room with the waitlist enabled, a queued joiner is dropped
+	// if they don't repeat the join request within WaitlistTimeout, each request waits up to WaitlistPollTimeout
+	MaxWaitlistSize     uint16 = 20
+	WaitlistTimeout            = 30 * time.Second
+	WaitlistPollTimeout        = 8 * time.Second
+
+	// wrong room password attempts allowed within PasswordAttemptWindow before joining the room is blocked for PasswordLockout
+	MaxPasswordAttempts   uint16 = 5
+	PasswordAttemptWindow        = 10 * time.Minute
+	PasswordLockout              = time.Minute
+
+	// typing indicators are cleared when not refreshed within TypingTimeout,
+	// a participant can start typing once every TypingCooldown
+	TypingTimeout  = 5 * time.Second
+	TypingCooldown = time.Second
+
+	// most recent chat messages kept in memory by rooms with the history enabled
+	HistorySize uint16 = 50
+
+	// most recent chat messages that still collect delivery and read receipts,
+	// senders get the receipts that changed at most once every ReceiptFlushInterval
+	ReceiptWindow        uint16 = 100
+	ReceiptFlushInterval        = 250 * time.Millisecond
+
+	// chat messages a participant can send in a row, after that one more is allowed every MessageRefill
+	MessageBurst  uint16 = 5
+	MessageRefill        = time.Second
+)
+
 func LoadConfig() {
 	if SecretKey == "" {
 		SecretKey = os.Getenv("SECRET_KEY")
@@ -23,4 +68,81 @@ func LoadConfig() {
 	if SecretKey == "" || APIKey == "" {
 		log.Fatal("Missing mandatory environment variables (SECRET_KEY, API_KEY)")
 	}
+
+	loadDuration("ROOM_LIFETIME", &RoomLifetime)
+	loadDuration("MIN_ROOM_LIFETIME", &MinRoomLifetime)
+	loadDuration("MAX_ROOM_LIFETIME", &MaxRoomLifetime)
+	loadDuration("ROOM_EXTENSION", &RoomExtension)
+	loadUint16("MAX_ROOM_PARTICIPANTS", &MaxRoomParticipants)
+	loadUint16("MAX_ROOM_SPECTATORS", &MaxRoomSpectators)
+	loadDuration("KNOCK_TIMEOUT", &KnockTimeout)
+	loadUint16("MAX_WAITLIST_SIZE", &MaxWaitlistSize)
+	loadDuration("WAITLIST_TIMEOUT", &WaitlistTimeout)
+	loadDuration("WAITLIST_POLL_TIMEOUT", &WaitlistPollTimeout)
+	loadUint16("MAX_PASSWORD_ATTEMPTS", &MaxPasswordAttempts)
+	loadDuration("PASSWORD_ATTEMPT_WINDOW", &PasswordAttemptWindow)
+	loadDuration("PASSWORD_LOCKOUT", &PasswordLockout)
+	loadDuration("TYPING_TIMEOUT", &TypingTimeout)
+	loadDuration("TYPING_COOLDOWN", &TypingCooldown)
+	loadUint16("HISTORY_SIZE", &HistorySize)
+	loadUint16("RECEIPT_WINDOW", &ReceiptWindow)
+	loadDuration("RECEIPT_FLUSH_INTERVAL", &ReceiptFlushInterval)
+	loadUint16("MESSAGE_BURST", &MessageBurst)
+	loadDuration("MESSAGE_REFILL", &MessageRefill)
+
+	if MinRoomLifetime > RoomLifetime || RoomLifetime > MaxRoomLifetime {
+		log.Fatal("ROOM_LIFETIME must be between MIN_ROOM_LIFETIME and MAX_ROOM_LIFETIME")
+	}
+
+	if MaxRoomParticipants < 2 {
+		log.Fatal("MAX_ROOM_PARTICIPANTS must be at least 2")
+	}
+
+	if WaitlistPollTimeout >= WaitlistTimeout {
+		log.Fatal("WAITLIST_POLL_TIMEOUT must be shorter than WAITLIST_TIMEOUT")
+	}
+
+	if MaxPasswordAttempts < 1 {
+		log.Fatal("MAX_PASSWORD_ATTEMPTS must be at least 1")
+	}
+
+	if HistorySize < 1 {
+		log.Fatal("HISTORY_SIZE must be at least 1")
+	}
+
+	if ReceiptWindow < 1 {
+		log.Fatal("RECEIPT_WINDOW must be at least 1")
+	}
+
+	if MessageBurst < 1 {
+		log.Fatal("MESSAGE_BURST must be at least 1")
+	}
+}
+
+func loadDuration(name string, target *time.Duration) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Fatalf("Invalid duration in environment variable %s: %q", name, value)
+	}
+
+	*target = d
+}
+
+func loadUint16(name string, target *uint16) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+
+	n, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		log.Fatalf("Invalid number in environment variable %s: %q", name, value)
+	}
+
+	*target = uint16(n)
 }
@@ -0,0 +1,165 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"kseli/auth"
+	"kseli/common"
+)
+
+// Errors returned when a participant can't be admitted to the room
+var (
+	errInvalidInvite = errors.New("Invalid invite link.")
+	errInviteUsedUp  = errors.New("This invite link has been used up.")
+	errInviteExpired = errors.New("This invite link has expired.")
+	errRoomLocked    = errors.New("Chat Room is locked.")
+	errAlreadyInRoom = errors.New("You can not join a room you are already in.")
+	errRoomFull      = errors.New("Chat Room is full.")
+	errKnockDenied   = errors.New("Your request to join was denied.")
+)
+
+// banError is returned when the joiner is banned, remaining is negative for bans that last as long as the room
+type banError struct {
+	remaining time.Duration
+}
+
+func (e *banError) Error() string {
+	if e.remaining < 0 {
+		return "You are banned from this room."
+	}
+	return fmt.Sprintf("You are banned from this room, try again in %d seconds.", e.retryAfter())
+}
+
+// retryAfter is the remaining time of the ban rounded up to whole seconds
+func (e *banError) retryAfter() int {
+	return int((e.remaining + time.Second - 1) / time.Second)
+}
+
+// make sure caller locks room for reading
+// checkInviteAccess checks the parts of a join that only depend on the invite and the room,
+// it runs before the password so a dead link or a locked room doesn't count as a password attempt
+func (r *Room) checkInviteAccess(invite *auth.InviteClaims) error {
+	if err := r.checkOpen(); err != nil {
+		return err
+	}
+
+	if invite.SecretKey != r.secretKey {
+		return errInvalidInvite
+	}
+
+	if err := r.checkInvite(invite.InviteID); err != nil {
+		return err
+	}
+
+	if r.locked {
+		return errRoomLocked
+	}
+
+	return nil
+}
+
+// admission is the outcome of a join that wasn't refused, only one of participant, knock and waiter is set
+type admission struct {
+	participant *Participant
+	// knock is set while the admin hasn't answered the join request
+	knock *Knock
+	// waiter is set while the joiner is queued for a full room, queued tells if this join request queued them
+	waiter *Waiter
+	queued bool
+}
+
+// admit runs every join check and adds the participant under a single lock,
+// so concurrent joins can't overfill the room or end up with the same username.
+// In knock mode a pending knock is returned instead of a participant until the admin answers,
+// with the waitlist enabled a joiner to a full room is queued instead of refused.
+func (r *Room) admit(sessionID, username string, invite *auth.InviteClaims) (admission, error) {
+	r.mu.Lock()
+	if err := r.checkInviteAccess(invite); err != nil {
+		r.mu.Unlock()
+		return admission{}, err
+	}
+
+	// a queued joiner repeats the join request until they are admitted
+	if adm, ok, err := r.pickUpWaiter(sessionID, username); ok {
+		r.mu.Unlock()
+		return adm, err
+	}
+
+	if _, alreadyInRoom := r.participants[sessionID]; alreadyInRoom {
+		r.mu.Unlock()
+		return admission{}, errAlreadyInRoom
+	}
+
+	if remaining, banned := r.banRemaining(sessionID); banned {
+		r.mu.Unlock()
+		return admission{}, &banError{remaining: remaining}
+	}
+
+	full := r.isFull(invite.Spectator)
+	if full && invite.Spectator {
+		r.mu.Unlock()
+		return admission{}, errSpectatorsFull
+	}
+	if full && !r.waitlist {
+		r.mu.Unlock()
+		return admission{}, errRoomFull
+	}
+
+	if r.isUsernameTaken(username) {
+		r.mu.Unlock()
+		return admission{}, errUsernameTaken
+	}
+
+	if r.knock {
+		// In knock mode the join request is repeated by the client until the admin answers
+		knock, isNew := r.knockForJoin(sessionID, username)
+
+		switch knock.status {
+		case knockDenied:
+			r.mu.Unlock()
+			return admission{}, errKnockDenied
+
+		case knockPending:
+			r.mu.Unlock()
+			if isNew {
+				r.broadcastKnock(knock)
+			}
+			return admission{knock: knock}, nil
+		}
+	}
+
+	// in knock mode the joiner is only queued once the admin approved them
+	if full {
+		w, err := r.enqueueWaiter(sessionID, username, invite)
+		r.mu.Unlock()
+		if err != nil {
+			return admission{}, err
+		}
+		return admission{waiter: w, queued: true}, nil
+	}
+
+	role := common.Member
+	if invite.Spectator {
+		role = common.Spectator
+	}
+
+	p := &Participant{
+		sessionID: sessionID,
+		id:        r.nextParticipantID,
+		username:  username,
+		role:      role,
+		inviteID:  invite.InviteID,
+		tokenExp:  r.expiresAt,
+		// messages sent before the participant was admitted can't be read by them
+		readSeq: r.msgSeq,
+	}
+	r.nextParticipantID++
+
+	r.join(p)
+	r.consumeInvite(invite.InviteID)
+	r.mu.Unlock()
+
+	return admission{participant: p}, nil
+}
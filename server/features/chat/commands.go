@@ -0,0 +1,218 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"kseli/common"
+)
+
+// WSCommand is the envelope of a text frame sent by a client to ask the server for an action, it mirrors WSMsg.
+// ID is picked by the client, commands sent with an ID are answered with an "ack" or an "error" carrying the same ID.
+// Older clients send chat messages as plain encrypted text that never starts with '{',
+// so any text frame that isn't a JSON object with a type is still treated as a chat message.
+type WSCommand struct {
+	CmdType string          `json:"type"`
+	ID      uint32          `json:"id,omitempty"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// AckMsg tells the client the command with the ID was carried out,
+// Msg is set when the command posted a chat message
+type AckMsg struct {
+	ID  uint32   `json:"id"`
+	Msg *MsgMeta `json:"msg,omitempty"`
+}
+
+// commandFunc carries out a command for the sender with the given ID and role,
+// the returned message is sent back as an error, an empty one means success.
+// The returned ack is sent to the sender when the command has an ID.
+type commandFunc func(r *Room, id uint32, role common.Role, data json.RawMessage) (AckMsg, string)
+
+var commands = map[string]commandFunc{
+	"msg":      (*Room).handleMsgCommand,
+	"transfer": (*Room).handleTransferCommand,
+	"knock-approve": func(r *Room, _ uint32, role common.Role, data json.RawMessage) (AckMsg, string) {
+		return AckMsg{}, r.handleKnockCommand(role, data, true)
+	},
+	"knock-deny": func(r *Room, _ uint32, role common.Role, data json.RawMessage) (AckMsg, string) {
+		return AckMsg{}, r.handleKnockCommand(role, data, false)
+	},
+	"rename":  (*Room).handleRenameCommand,
+	"lock":    (*Room).handleLockCommand,
+	"history": (*Room).handleHistoryCommand,
+	"typing":  (*Room).handleTypingCommand,
+	"read":    (*Room).handleReadCommand,
+}
+
+func parseWSCommand(frame []byte) (WSCommand, bool) {
+	var cmd WSCommand
+
+	if !bytes.HasPrefix(frame, []byte("{")) {
+		return cmd, false
+	}
+
+	if err := json.Unmarshal(frame, &cmd); err != nil || cmd.CmdType == "" {
+		return cmd, false
+	}
+
+	return cmd, true
+}
+
+func (r *Room) handleCommand(id uint32, cmd WSCommand) {
+	r.mu.RLock()
+	role := r.getParticipantRole(id)
+	r.mu.RUnlock()
+
+	if role == 0 {
+		return
+	}
+
+	var ack AckMsg
+	var err string
+
+	if handle, known := commands[cmd.CmdType]; known {
+		ack, err = handle(r, id, role, cmd.Data)
+	} else {
+		err = "Unknown command."
+	}
+
+	if err == "" && cmd.ID == 0 {
+		return
+	}
+
+	var reply []byte
+	if err != "" {
+		reply = encodeWSMessage("error", ErrorMsg{ID: cmd.ID, Message: err})
+	} else {
+		ack.ID = cmd.ID
+		reply = encodeWSMessage("ack", ack)
+	}
+
+	// The participant could have been kicked or banned while the command ran, their queue is closed then
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if p, exists := r.getParticipantByID(id); exists {
+		p.send(reply)
+	}
+}
+
+type MsgRequest struct {
+	Content string `json:"content"`
+}
+
+func (r *Room) handleMsgCommand(id uint32, _ common.Role, data json.RawMessage) (AckMsg, string) {
+	var req MsgRequest
+
+	if err := json.Unmarshal(data, &req); err != nil || req.Content == "" {
+		return AckMsg{}, "Message content is required in the command."
+	}
+
+	meta, err := r.postChatMsg(id, req.Content, true)
+	if err != nil {
+		return AckMsg{}, err.Error()
+	}
+
+	return AckMsg{Msg: &meta}, ""
+}
+
+func (r *Room) handleTransferCommand(id uint32, role common.Role, data json.RawMessage) (AckMsg, string) {
+	var req UserRequest
+
+	if err := json.Unmarshal(data, &req); err != nil || req.TargetUserID == 0 {
+		return AckMsg{}, "User Id is required in the command."
+	}
+
+	if !role.Can(common.PermTransfer) {
+		return AckMsg{}, "You don't have permission to transfer the admin role."
+	}
+
+	if id == req.TargetUserID {
+		return AckMsg{}, "You are already the admin of the room."
+	}
+
+	if _, err := r.transferAdmin(id, req.TargetUserID); err != nil {
+		return AckMsg{}, err.Error()
+	}
+
+	return AckMsg{}, ""
+}
+
+func (r *Room) handleRenameCommand(id uint32, role common.Role, data json.RawMessage) (AckMsg, string) {
+	var req RenameRequest
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		return AckMsg{}, "Username is required in the command."
+	}
+
+	fieldErrors := make(map[string]string, 1) // field name -> error message
+
+	validateUsername(req.Username, fieldErrors)
+
+	if len(fieldErrors) > 0 {
+		return AckMsg{}, fieldErrors["username"]
+	}
+
+	if req.TargetUserID != 0 && req.TargetUserID != id {
+		if !role.Can(common.PermRename) {
+			return AckMsg{}, "You don't have permission to rename other participants."
+		}
+
+		r.mu.RLock()
+		targetRole := r.getParticipantRole(req.TargetUserID)
+		r.mu.RUnlock()
+
+		if targetRole != 0 && !role.Outranks(targetRole) {
+			return AckMsg{}, "You can't rename a participant with the same or a higher role."
+		}
+	} else {
+		req.TargetUserID = id
+	}
+
+	if _, err := r.rename(req.TargetUserID, req.Username); err != nil {
+		return AckMsg{}, err.Error()
+	}
+
+	return AckMsg{}, ""
+}
+
+type KnockRequest struct {
+	KnockID string `json:"id"`
+}
+
+func (r *Room) handleKnockCommand(role common.Role, data json.RawMessage, approve bool) string {
+	var req KnockRequest
+
+	if err := json.Unmarshal(data, &req); err != nil || req.KnockID == "" {
+		return "Join request Id is required in the command."
+	}
+
+	if !role.Can(common.PermInvite) {
+		return "You don't have permission to answer join requests."
+	}
+
+	if err := r.answerKnock(req.KnockID, approve); err != nil {
+		return err.Error()
+	}
+
+	return ""
+}
+
+func (r *Room) handleLockCommand(_ uint32, role common.Role, data json.RawMessage) (AckMsg, string) {
+	var msg LockMsg
+
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return AckMsg{}, "Lock state is required in the command."
+	}
+
+	if !role.Can(common.PermLock) {
+		return AckMsg{}, "You don't have permission to lock this room."
+	}
+
+	if err := r.setLocked(msg.Locked); err != nil {
+		return AckMsg{}, err.Error()
+	}
+
+	return AckMsg{}, ""
+}
@@ -2,13 +2,16 @@ package chat
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"kseli/auth"
 	"kseli/common"
+	"kseli/config"
 	"kseli/middleware"
 
 	"github.com/gobwas/ws"
@@ -17,7 +20,13 @@ import (
 
 type CreateRoomRequest struct {
 	Username        string `json:"username"`
-	MaxParticipants uint8  `json:"maxParticipants"`
+	MaxParticipants uint16 `json:"maxParticipants"`
+	DurationMinutes uint16 `json:"durationMinutes,omitempty"`
+	AutoPromote     bool   `json:"autoPromote,omitempty"`
+	Knock           bool   `json:"knock,omitempty"`
+	Waitlist        bool   `json:"waitlist,omitempty"`
+	History         bool   `json:"history,omitempty"`
+	Password        string `json:"password,omitempty"`
 }
 
 type CreateRoomResponse struct {
@@ -27,7 +36,7 @@ type CreateRoomResponse struct {
 
 func CreateRoomHandler(s Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, 128)
+		r.Body = http.MaxBytesReader(w, r.Body, 512)
 
 		var req CreateRoomRequest
 
@@ -36,11 +45,21 @@ func CreateRoomHandler(s Storage) http.HandlerFunc {
 			return
 		}
 
-		fieldErrors := make(map[string]string, 2) // field name -> error message
+		fieldErrors := make(map[string]string, 4) // field name -> error message
 
 		validateUsername(req.Username, fieldErrors)
-		if req.MaxParticipants < 2 || req.MaxParticipants > 5 {
-			fieldErrors["maxParticipants"] = "Max participants must be between 2 and 5."
+		validatePassword(req.Password, fieldErrors)
+		if req.MaxParticipants < 2 || req.MaxParticipants > config.MaxRoomParticipants {
+			fieldErrors["maxParticipants"] = fmt.Sprintf("Max participants must be between 2 and %d.", config.MaxRoomParticipants)
+		}
+
+		lifetime := config.RoomLifetime
+		if req.DurationMinutes != 0 {
+			lifetime = time.Duration(req.DurationMinutes) * time.Minute
+			if lifetime < config.MinRoomLifetime || lifetime > config.MaxRoomLifetime {
+				fieldErrors["durationMinutes"] = fmt.Sprintf("Duration must be between %d and %d minutes.",
+					int(config.MinRoomLifetime.Minutes()), int(config.MaxRoomLifetime.Minutes()))
+			}
 		}
 
 		if len(fieldErrors) > 0 {
@@ -48,23 +67,25 @@ func CreateRoomHandler(s Storage) http.HandlerFunc {
 			return
 		}
 
+		var passwordHash []byte
+		if req.Password != "" {
+			var err error
+			if passwordHash, err = auth.HashPassword(req.Password); err != nil {
+				common.WriteError(w, http.StatusInternalServerError, "Failed to set room password: "+err.Error())
+				return
+			}
+		}
+
 		roomID := generateUniqueRoomID(s)
 		roomSecretKey := generateRandomString(10)
-		roomExpiration := time.Now().Add(30 * time.Minute).Unix()
-
-		inviteClaims := auth.InviteClaims{
-			RoomID:    roomID,
-			SecretKey: roomSecretKey,
-			Exp:       roomExpiration,
-		}
+		now := time.Now()
+		roomExpiration := now.Add(lifetime).Unix()
 
-		inviteToken, err := auth.CreateToken(inviteClaims)
+		inviteLink, err := createInviteLink(r.Header.Get("Origin"), roomID, roomSecretKey, roomExpiration)
 		if err != nil {
 			common.WriteError(w, http.StatusInternalServerError, "Failed to create invite token: "+err.Error())
 			return
 		}
-		origin := r.Header.Get("Origin")
-		inviteLink := fmt.Sprintf("%s/join#invite=%s", origin, inviteToken)
 
 		room := &Room{
 			nextParticipantID:  2,
@@ -73,10 +94,24 @@ func CreateRoomHandler(s Storage) http.HandlerFunc {
 			secretKey:          roomSecretKey,
 			inviteLink:         inviteLink,
 			participants:       make(map[string]*Participant, req.MaxParticipants),
-			bannedParticipants: make(map[string]struct{}),
+			participantsByID:   make(map[uint32]*Participant, req.MaxParticipants),
+			usernames:          make(map[string]*Participant, req.MaxParticipants),
+			bannedParticipants: make(map[string]*Ban),
+			invites:            make(map[string]*Invite),
+			knocks:             make(map[string]*Knock),
+			receipts:           newReceiptTracker(),
 			onClose:            func(roomID string) { s.DeleteRoom(roomID) },
-			onExpire:           time.AfterFunc(30*time.Minute, func() { s.RoomCleanupFunc()(roomID) }),
+			onExpire:           time.AfterFunc(lifetime, func() { s.RoomCleanupFunc()(roomID) }),
+			autoPromote:        req.AutoPromote,
+			knock:              req.Knock,
+			waitlist:           req.Waitlist,
+			createdAt:          now.Unix(),
 			expiresAt:          roomExpiration,
+			passwordHash:       passwordHash,
+		}
+
+		if req.History {
+			room.history = newMsgHistory(config.HistorySize)
 		}
 
 		sessionID, ok := r.Context().Value(auth.ParticipantSessionIDKey).(string)
@@ -90,6 +125,7 @@ func CreateRoomHandler(s Storage) http.HandlerFunc {
 			id:        1,
 			username:  req.Username,
 			role:      common.Admin,
+			tokenExp:  roomExpiration,
 		}
 
 		// no need to lock here since room is not in storage yet
@@ -108,7 +144,10 @@ func CreateRoomHandler(s Storage) http.HandlerFunc {
 		token, err := auth.CreateToken(claims)
 		if err != nil {
 			room.participants = nil
+			room.participantsByID = nil
+			room.usernames = nil
 			room.bannedParticipants = nil
+			room.invites = nil
 			room.onClose = nil
 			room.onExpire.Stop()
 			room.onExpire = nil
@@ -126,16 +165,20 @@ func CreateRoomHandler(s Storage) http.HandlerFunc {
 
 type JoinRoomRequest struct {
 	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
 }
 
 type JoinRoomResponse struct {
-	RoomID string `json:"roomId"`
-	Token  string `json:"token"`
+	RoomID  string `json:"roomId"`
+	Token   string `json:"token,omitempty"`
+	Pending bool   `json:"pending,omitempty"`
+	// place in the waitlist of a full room, the join request is repeated until the token is returned
+	Position int `json:"position,omitempty"`
 }
 
 func JoinRoomHandler(s Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, 128)
+		r.Body = http.MaxBytesReader(w, r.Body, 512)
 
 		var req JoinRoomRequest
 
@@ -172,68 +215,81 @@ func JoinRoomHandler(s Storage) http.HandlerFunc {
 		}
 
 		room.mu.RLock()
-		if inviteClaims.SecretKey != room.secretKey {
-			room.mu.RUnlock()
-			common.WriteError(w, http.StatusForbidden, "Invalid invite link.")
-			return
-		}
+		err := room.checkInviteAccess(inviteClaims)
+		// a knocking or queued joiner repeats the join request, the password is only checked on the first one
+		needsPassword := room.hasPassword() && !room.hasPendingJoin(sessionID)
+		room.mu.RUnlock()
 
-		if _, alreadyInRoom := room.participants[sessionID]; alreadyInRoom {
-			room.mu.RUnlock()
-			common.WriteError(w, http.StatusBadRequest, "You can not join a room you are already in.")
+		if err != nil {
+			writeJoinError(w, err)
 			return
 		}
 
-		if _, banned := room.bannedParticipants[sessionID]; banned {
-			room.mu.RUnlock()
-			common.WriteError(w, http.StatusForbidden, "You are banned from this room.")
-			return
+		// the password is checked before anything else about the room is revealed to the joiner
+		if needsPassword {
+			if req.Password == "" {
+				common.WriteError(w, http.StatusUnauthorized, "This room requires a password.")
+				return
+			}
+
+			ok, retryAfter := room.checkPassword(req.Password)
+			if retryAfter > 0 {
+				seconds := int((retryAfter + time.Second - 1) / time.Second)
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				common.WriteError(w, http.StatusTooManyRequests, fmt.Sprintf("Too many wrong password attempts, try again in %d seconds.", seconds))
+				return
+			}
+			if !ok {
+				common.WriteError(w, http.StatusForbidden, "Incorrect room password.")
+				return
+			}
 		}
 
-		if uint8(len(room.participants)) == room.maxParticipants {
-			room.mu.RUnlock()
-			common.WriteError(w, http.StatusConflict, "Chat Room is full.")
+		adm, err := room.admit(sessionID, req.Username, inviteClaims)
+		if err == nil && adm.waiter != nil && !adm.queued {
+			// a queued joiner came back, hold the request until a slot frees up
+			adm, err = room.waitForSlot(r.Context(), adm.waiter)
+		}
+		if err != nil {
+			writeJoinError(w, err)
 			return
 		}
 
-		if room.isUsernameTaken(req.Username) {
-			room.mu.RUnlock()
-			fieldErrors["username"] = "This username is taken."
-			common.WriteFieldErrors(w, http.StatusBadRequest, fieldErrors)
+		if adm.knock != nil {
+			common.WriteJSON(w, http.StatusAccepted, &JoinRoomResponse{
+				RoomID:  inviteClaims.RoomID,
+				Pending: true,
+			})
 			return
 		}
-		room.mu.RUnlock()
 
-		room.mu.Lock()
-		pID := room.nextParticipantID
-		room.nextParticipantID++
+		if adm.waiter != nil {
+			room.mu.RLock()
+			position := room.waitlistPosition(adm.waiter)
+			room.mu.RUnlock()
 
-		p := &Participant{
-			sessionID: sessionID,
-			id:        pID,
-			username:  req.Username,
-			role:      common.Member,
+			common.WriteJSON(w, http.StatusAccepted, &JoinRoomResponse{
+				RoomID:   inviteClaims.RoomID,
+				Pending:  true,
+				Position: position,
+			})
+			return
 		}
 
-		room.join(p)
-		room.mu.Unlock()
+		p := adm.participant
 
 		claims := auth.Claims{
 			UserID:   p.id,
 			Username: p.username,
 			Role:     p.role,
 			RoomID:   inviteClaims.RoomID,
-			Exp:      time.Now().Add(30 * time.Minute).Unix(),
+			Exp:      p.tokenExp,
 		}
 
 		token, err := auth.CreateToken(claims)
 		if err != nil {
 			room.mu.Lock()
-			// if p.wsTimeout != nil {
-			// 	p.wsTimeout.Stop()
-			// 	p.wsTimeout = nil
-			// }
-			delete(room.participants, sessionID)
+			room.removeParticipant(p)
 			room.mu.Unlock()
 			common.WriteError(w, http.StatusInternalServerError, "Failed to create token: "+err.Error())
 			return
@@ -246,12 +302,44 @@ func JoinRoomHandler(s Storage) http.HandlerFunc {
 	}
 }
 
+// writeJoinError maps the errors of Room.admit to a response
+func writeJoinError(w http.ResponseWriter, err error) {
+	var banErr *banError
+
+	switch {
+	case errors.As(err, &banErr):
+		if banErr.remaining >= 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(banErr.retryAfter()))
+		}
+		common.WriteError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, errInvalidInvite), errors.Is(err, errInviteUsedUp), errors.Is(err, errInviteExpired),
+		errors.Is(err, errKnockDenied):
+		common.WriteError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, errRoomLocked):
+		common.WriteError(w, http.StatusLocked, err.Error())
+	case errors.Is(err, errAlreadyInRoom):
+		common.WriteError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, errRoomFull), errors.Is(err, errWaitlistFull), errors.Is(err, errSpectatorsFull):
+		common.WriteError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, errUsernameTaken):
+		common.WriteFieldErrors(w, http.StatusBadRequest, map[string]string{"username": err.Error()})
+	default:
+		writeRoomError(w, err, http.StatusNotFound)
+	}
+}
+
 type GetRoomResponse struct {
 	UserRole        common.Role       `json:"userRole"`
-	MaxParticipants uint8             `json:"maxParticipants"`
+	MaxParticipants uint16            `json:"maxParticipants"`
 	Participants    []ParticipantView `json:"participants"`
+	Spectators      []ParticipantView `json:"spectators,omitempty"`
 	ExpiresAt       int64             `json:"expiresAt"`
 	InviteLink      string            `json:"inviteLink,omitempty"`
+	Knocks          []Knock           `json:"knocks,omitempty"`
+	HasPassword     bool              `json:"hasPassword,omitempty"`
+	Locked          bool              `json:"locked"`
+	History         bool              `json:"history,omitempty"`
+	SlowModeSeconds uint16            `json:"slowModeSeconds,omitempty"`
 }
 
 func GetRoomHandler(s Storage) http.HandlerFunc {
@@ -283,26 +371,42 @@ func GetRoomHandler(s Storage) http.HandlerFunc {
 			return
 		}
 
-		_, exists = room.getParticipantByID(claims.UserID)
+		if err := room.checkOpen(); err != nil {
+			room.mu.RUnlock()
+			writeRoomError(w, err, http.StatusNotFound)
+			return
+		}
+
+		p, exists := room.getParticipantByID(claims.UserID)
 		if !exists {
 			room.mu.RUnlock()
 			common.WriteError(w, http.StatusForbidden, "You are not in this room and can't retrieve the details. Try joining again.")
 			return
 		}
 
+		canInvite := p.role.Can(common.PermInvite)
+
 		inviteLink := ""
-		if claims.Role == common.Admin {
+		var knocks []Knock
+		if canInvite {
 			inviteLink = room.inviteLink
+			knocks = room.getKnocksAsSlice()
 		}
 
-		participants := room.getParticipantsAsSlice()
+		participants, spectators := room.getParticipantsAsSlice(canInvite)
 
 		resp := &GetRoomResponse{
-			UserRole:        claims.Role,
+			UserRole:        p.role,
 			MaxParticipants: room.maxParticipants,
 			Participants:    participants,
+			Spectators:      spectators,
 			ExpiresAt:       room.expiresAt,
 			InviteLink:      inviteLink,
+			Knocks:          knocks,
+			HasPassword:     room.hasPassword(),
+			Locked:          room.locked,
+			History:         room.history != nil,
+			SlowModeSeconds: uint16(room.slowMode / time.Second),
 		}
 		room.mu.RUnlock()
 
@@ -338,10 +442,11 @@ func DeleteRoomHandler(s Storage) http.HandlerFunc {
 			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
 			return
 		}
+		role := room.getParticipantRole(claims.UserID)
 		room.mu.RUnlock()
 
-		if claims.Role != common.Admin {
-			common.WriteError(w, http.StatusForbidden, "You are not an admin and can't close this room.")
+		if !role.Can(common.PermClose) {
+			common.WriteError(w, http.StatusForbidden, "You don't have permission to close this room.")
 			return
 		}
 
@@ -351,15 +456,114 @@ func DeleteRoomHandler(s Storage) http.HandlerFunc {
 	}
 }
 
-type UserRequest struct {
-	TargetUserID uint8 `json:"userId"`
+type ExtendRoomResponse struct {
+	ExpiresAt int64  `json:"expiresAt"`
+	Token     string `json:"token,omitempty"`
+}
+
+func ExtendRoomHandler(s Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		roomID := r.PathValue("roomID")
+
+		if err := validateRoomId(roomID); err != "" {
+			common.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		room, exists := s.GetRoom(roomID)
+		if !exists {
+			common.WriteError(w, http.StatusNotFound, "Chat Room not found.")
+			return
+		}
+
+		claims, ok := r.Context().Value(auth.ParticipantClaimsKey).(*auth.Claims)
+		if !ok || claims == nil {
+			common.WriteError(w, http.StatusInternalServerError, "Invalid authorizaton token.")
+			return
+		}
+
+		room.mu.RLock()
+		if claims.RoomID != room.roomID {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
+			return
+		}
+		role := room.getParticipantRole(claims.UserID)
+		room.mu.RUnlock()
+
+		if !role.Can(common.PermExtend) {
+			common.WriteError(w, http.StatusForbidden, "You don't have permission to extend this room.")
+			return
+		}
+
+		expiresAt, tokens, err := room.extend(r.Header.Get("Origin"))
+		if err != nil {
+			writeRoomError(w, err, http.StatusConflict)
+			return
+		}
+
+		common.WriteJSON(w, http.StatusOK, &ExtendRoomResponse{
+			ExpiresAt: expiresAt,
+			Token:     tokens[claims.UserID],
+		})
+	}
+}
+
+func LockRoomHandler(s Storage, locked bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		roomID := r.PathValue("roomID")
+
+		if err := validateRoomId(roomID); err != "" {
+			common.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		room, exists := s.GetRoom(roomID)
+		if !exists {
+			common.WriteError(w, http.StatusNotFound, "Chat Room not found.")
+			return
+		}
+
+		claims, ok := r.Context().Value(auth.ParticipantClaimsKey).(*auth.Claims)
+		if !ok || claims == nil {
+			common.WriteError(w, http.StatusInternalServerError, "Invalid authorizaton token.")
+			return
+		}
+
+		room.mu.RLock()
+		if claims.RoomID != room.roomID {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
+			return
+		}
+		role := room.getParticipantRole(claims.UserID)
+		room.mu.RUnlock()
+
+		if !role.Can(common.PermLock) {
+			common.WriteError(w, http.StatusForbidden, "You don't have permission to lock this room.")
+			return
+		}
+
+		if err := room.setLocked(locked); err != nil {
+			writeRoomError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type SlowModeRequest struct {
+	Seconds uint16 `json:"seconds"`
 }
 
-func performRoomAction(s Storage, action string, actionFunc func(r *Room, targetID uint8) error) http.HandlerFunc {
+func SetSlowModeHandler(s Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		r.Body = http.MaxBytesReader(w, r.Body, 128)
 
-		var req UserRequest
+		var req SlowModeRequest
 
 		roomID := r.PathValue("roomID")
 
@@ -373,8 +577,10 @@ func performRoomAction(s Storage, action string, actionFunc func(r *Room, target
 			return
 		}
 
-		if req.TargetUserID == 0 {
-			common.WriteError(w, http.StatusBadRequest, "User Id is required in the request.")
+		if req.Seconds > maxSlowModeSeconds {
+			common.WriteFieldErrors(w, http.StatusBadRequest, map[string]string{
+				"seconds": fmt.Sprintf("Slow mode must be between 0 and %d seconds.", maxSlowModeSeconds),
+			})
 			return
 		}
 
@@ -396,20 +602,85 @@ func performRoomAction(s Storage, action string, actionFunc func(r *Room, target
 			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
 			return
 		}
+		role := room.getParticipantRole(claims.UserID)
 		room.mu.RUnlock()
 
-		if claims.Role != common.Admin {
-			common.WriteError(w, http.StatusForbidden, fmt.Sprintf("You are not an admin and can't %s anyone from this room.", action))
+		if !role.Can(common.PermSlowMode) {
+			common.WriteError(w, http.StatusForbidden, "You don't have permission to change slow mode in this room.")
 			return
 		}
 
-		if claims.UserID == req.TargetUserID {
-			common.WriteError(w, http.StatusBadRequest, fmt.Sprintf("You can't %s yourself from the room.", action))
+		if err := room.setSlowMode(req.Seconds); err != nil {
+			writeRoomError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type PasswordRequest struct {
+	Password string `json:"password"`
+}
+
+func SetRoomPasswordHandler(s Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 512)
+
+		var req PasswordRequest
+
+		roomID := r.PathValue("roomID")
+
+		if err := validateRoomId(roomID); err != "" {
+			common.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			common.WriteError(w, http.StatusBadRequest, "Invalid JSON request body.")
+			return
+		}
+
+		fieldErrors := make(map[string]string, 1) // field name -> error message
+
+		validatePassword(req.Password, fieldErrors)
+
+		if len(fieldErrors) > 0 {
+			common.WriteFieldErrors(w, http.StatusBadRequest, fieldErrors)
+			return
+		}
+
+		room, exists := s.GetRoom(roomID)
+		if !exists {
+			common.WriteError(w, http.StatusNotFound, "Chat Room not found.")
+			return
+		}
+
+		claims, ok := r.Context().Value(auth.ParticipantClaimsKey).(*auth.Claims)
+		if !ok || claims == nil {
+			common.WriteError(w, http.StatusInternalServerError, "Invalid authorizaton token.")
+			return
+		}
+
+		room.mu.RLock()
+		if claims.RoomID != room.roomID {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
+			return
+		}
+		role := room.getParticipantRole(claims.UserID)
+		room.mu.RUnlock()
+
+		if !role.Can(common.PermPassword) {
+			common.WriteError(w, http.StatusForbidden, "You don't have permission to change the room password.")
 			return
 		}
 
-		if err := actionFunc(room, req.TargetUserID); err != nil {
-			common.WriteError(w, http.StatusNotFound, err.Error())
+		if err := room.setPassword(req.Password); errors.Is(err, errRoomClosed) {
+			writeRoomError(w, err, http.StatusGone)
+			return
+		} else if err != nil {
+			common.WriteError(w, http.StatusInternalServerError, "Failed to set room password: "+err.Error())
 			return
 		}
 
@@ -417,86 +688,828 @@ func performRoomAction(s Storage, action string, actionFunc func(r *Room, target
 	}
 }
 
-func KickParticipantHandler(s Storage) http.HandlerFunc {
-	return performRoomAction(s, "kick", func(r *Room, id uint8) error {
-		return r.kick(id)
-	})
+type UserRequest struct {
+	TargetUserID uint32 `json:"userId"`
+	// only used by mute and ban, 0 lasts until the participant is unmuted or unbanned
+	DurationMinutes uint16 `json:"durationMinutes,omitempty"`
+	// only used by kick and ban, shown to the removed participant and the rest of the room
+	Reason string `json:"reason,omitempty"`
 }
 
-func BanParticipantHandler(s Storage) http.HandlerFunc {
-	return performRoomAction(s, "ban", func(r *Room, id uint8) error {
-		return r.ban(id)
-	})
+// actionErrors holds the wording of the errors performRoomAction writes, it differs per action
+type actionErrors struct {
+	noPermission string
+	self         string
+	outranked    string
 }
 
-var WaitForTestClientWSSetup chan struct{}
+func performRoomAction(s Storage, perm common.Permission, errMsgs actionErrors, actionFunc func(r *Room, req UserRequest) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 512)
 
-func waitForTestClientWS() {
-	if WaitForTestClientWSSetup != nil {
-		<-WaitForTestClientWSSetup
-		time.Sleep(1 * time.Millisecond)
-	}
-}
+		var req UserRequest
 
-func RoomWSHandler(s Storage) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		_, errMsg := middleware.ValidateOriginHost(origin)
+		roomID := r.PathValue("roomID")
 
-		conn, _, _, err := ws.HTTPUpgrader{
-			Timeout: 2 * time.Second,
-		}.Upgrade(r, w)
-		if err != nil {
+		if err := validateRoomId(roomID); err != "" {
+			common.WriteError(w, http.StatusBadRequest, err)
 			return
 		}
 
-		waitForTestClientWS()
-
-		if errMsg != "" {
-			wsutil.WriteServerMessage(conn, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "invalid-origin"))
-			conn.Close()
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			common.WriteError(w, http.StatusBadRequest, "Invalid JSON request body.")
 			return
 		}
 
-		token := r.URL.Query().Get("token")
-		if token == "" {
-			wsutil.WriteServerMessage(conn, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "token-missing"))
-			conn.Close()
+		if req.TargetUserID == 0 {
+			common.WriteError(w, http.StatusBadRequest, "User Id is required in the request.")
 			return
 		}
 
-		claims, err := auth.ValidateToken[auth.Claims](token)
-		if err != nil {
-			wsutil.WriteServerMessage(conn, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "token-invalid"))
-			conn.Close()
+		if len([]rune(req.Reason)) > 100 {
+			common.WriteFieldErrors(w, http.StatusBadRequest, map[string]string{
+				"reason": "Reason can be at most 100 characters.",
+			})
 			return
 		}
 
-		room, exists := s.GetRoom(claims.RoomID)
+		room, exists := s.GetRoom(roomID)
 		if !exists {
-			wsutil.WriteServerMessage(conn, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "room-not-exists"))
-			conn.Close()
+			common.WriteError(w, http.StatusNotFound, "Chat Room not found.")
 			return
 		}
 
-		room.addWSConn(conn, claims.Username)
-	}
-}
+		claims, ok := r.Context().Value(auth.ParticipantClaimsKey).(*auth.Claims)
+		if !ok || claims == nil {
+			common.WriteError(w, http.StatusInternalServerError, "Invalid authorizaton token.")
+			return
+		}
 
-func validateUsername(username string, fieldErrors map[string]string) {
-	if username == "" {
-		fieldErrors["username"] = "Username cannot be empty."
-		return
-	}
+		room.mu.RLock()
+		if claims.RoomID != room.roomID {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
+			return
+		}
+		role := room.getParticipantRole(claims.UserID)
+		targetRole := room.getParticipantRole(req.TargetUserID)
+		room.mu.RUnlock()
 
-	if strings.Contains(username, " ") {
-		fieldErrors["username"] = "Username cannot contain spaces."
-		return
-	}
+		if !role.Can(perm) {
+			common.WriteError(w, http.StatusForbidden, errMsgs.noPermission)
+			return
+		}
 
-	runeCount := len([]rune(username))
-	if runeCount < 3 || runeCount > 15 {
-		fieldErrors["username"] = "Username must be between 3 and 15 characters."
+		if claims.UserID == req.TargetUserID {
+			common.WriteError(w, http.StatusBadRequest, errMsgs.self)
+			return
+		}
+
+		if targetRole != 0 && !role.Outranks(targetRole) {
+			common.WriteError(w, http.StatusForbidden, errMsgs.outranked)
+			return
+		}
+
+		if err := actionFunc(room, req); err != nil {
+			writeRoomError(w, err, http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func KickParticipantHandler(s Storage) http.HandlerFunc {
+	return performRoomAction(s, common.PermKick, actionErrors{
+		noPermission: "You don't have permission to kick anyone from this room.",
+		self:         "You can't kick yourself from the room.",
+		outranked:    "You can't kick a participant with the same or a higher role.",
+	}, func(r *Room, req UserRequest) error {
+		return r.kick(req.TargetUserID, req.Reason)
+	})
+}
+
+func BanParticipantHandler(s Storage) http.HandlerFunc {
+	return performRoomAction(s, common.PermBan, actionErrors{
+		noPermission: "You don't have permission to ban anyone from this room.",
+		self:         "You can't ban yourself from the room.",
+		outranked:    "You can't ban a participant with the same or a higher role.",
+	}, func(r *Room, req UserRequest) error {
+		return r.ban(req.TargetUserID, time.Duration(req.DurationMinutes)*time.Minute, req.Reason)
+	})
+}
+
+func MuteParticipantHandler(s Storage) http.HandlerFunc {
+	return performRoomAction(s, common.PermMute, actionErrors{
+		noPermission: "You don't have permission to mute anyone in this room.",
+		self:         "You can't mute yourself.",
+		outranked:    "You can't mute a participant with the same or a higher role.",
+	}, func(r *Room, req UserRequest) error {
+		return r.mute(req.TargetUserID, time.Duration(req.DurationMinutes)*time.Minute)
+	})
+}
+
+func UnmuteParticipantHandler(s Storage) http.HandlerFunc {
+	return performRoomAction(s, common.PermMute, actionErrors{
+		noPermission: "You don't have permission to unmute anyone in this room.",
+		self:         "You can't unmute yourself.",
+		outranked:    "You can't unmute a participant with the same or a higher role.",
+	}, func(r *Room, req UserRequest) error {
+		return r.unmute(req.TargetUserID)
+	})
+}
+
+type RotateInviteResponse struct {
+	InviteLink string `json:"inviteLink"`
+}
+
+func RotateInviteHandler(s Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		roomID := r.PathValue("roomID")
+
+		if err := validateRoomId(roomID); err != "" {
+			common.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		room, exists := s.GetRoom(roomID)
+		if !exists {
+			common.WriteError(w, http.StatusNotFound, "Chat Room not found.")
+			return
+		}
+
+		claims, ok := r.Context().Value(auth.ParticipantClaimsKey).(*auth.Claims)
+		if !ok || claims == nil {
+			common.WriteError(w, http.StatusInternalServerError, "Invalid authorizaton token.")
+			return
+		}
+
+		room.mu.RLock()
+		if claims.RoomID != room.roomID {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
+			return
+		}
+		role := room.getParticipantRole(claims.UserID)
+		room.mu.RUnlock()
+
+		if !role.Can(common.PermRotateInvite) {
+			common.WriteError(w, http.StatusForbidden, "You don't have permission to change the invite link of this room.")
+			return
+		}
+
+		inviteLink, err := room.rotateInvite(r.Header.Get("Origin"), claims.UserID)
+		if errors.Is(err, errRoomClosed) {
+			writeRoomError(w, err, http.StatusGone)
+			return
+		}
+		if err != nil {
+			common.WriteError(w, http.StatusInternalServerError, "Failed to create invite token: "+err.Error())
+			return
+		}
+
+		common.WriteJSON(w, http.StatusOK, &RotateInviteResponse{InviteLink: inviteLink})
+	}
+}
+
+type CreateInviteRequest struct {
+	Label            string `json:"label,omitempty"`
+	MaxUses          uint16 `json:"maxUses,omitempty"`
+	ExpiresInMinutes uint16 `json:"expiresInMinutes,omitempty"`
+	Spectator        bool   `json:"spectator,omitempty"`
+}
+
+type CreateInviteResponse struct {
+	InviteID   string `json:"inviteId"`
+	InviteLink string `json:"inviteLink"`
+	ExpiresAt  int64  `json:"expiresAt"`
+}
+
+func CreateInviteHandler(s Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 512)
+
+		var req CreateInviteRequest
+
+		roomID := r.PathValue("roomID")
+
+		if err := validateRoomId(roomID); err != "" {
+			common.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			common.WriteError(w, http.StatusBadRequest, "Invalid JSON request body.")
+			return
+		}
+
+		if len([]rune(req.Label)) > 30 {
+			common.WriteFieldErrors(w, http.StatusBadRequest, map[string]string{
+				"label": "Label can't be longer than 30 characters.",
+			})
+			return
+		}
+
+		room, exists := s.GetRoom(roomID)
+		if !exists {
+			common.WriteError(w, http.StatusNotFound, "Chat Room not found.")
+			return
+		}
+
+		claims, ok := r.Context().Value(auth.ParticipantClaimsKey).(*auth.Claims)
+		if !ok || claims == nil {
+			common.WriteError(w, http.StatusInternalServerError, "Invalid authorizaton token.")
+			return
+		}
+
+		room.mu.RLock()
+		if claims.RoomID != room.roomID {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
+			return
+		}
+		role := room.getParticipantRole(claims.UserID)
+		room.mu.RUnlock()
+
+		if !role.Can(common.PermInvite) {
+			common.WriteError(w, http.StatusForbidden, "You don't have permission to invite anyone to this room.")
+			return
+		}
+
+		lifetime := time.Duration(req.ExpiresInMinutes) * time.Minute
+
+		inv, inviteLink, err := room.createInvite(r.Header.Get("Origin"), req.Label, req.MaxUses, lifetime, req.Spectator)
+		if errors.Is(err, errRoomClosed) {
+			writeRoomError(w, err, http.StatusGone)
+			return
+		}
+		if err != nil {
+			common.WriteError(w, http.StatusInternalServerError, "Failed to create invite token: "+err.Error())
+			return
+		}
+
+		common.WriteJSON(w, http.StatusCreated, &CreateInviteResponse{
+			InviteID:   inv.ID,
+			InviteLink: inviteLink,
+			ExpiresAt:  inv.ExpiresAt,
+		})
+	}
+}
+
+type GetInvitesResponse struct {
+	Invites []Invite `json:"invites"`
+}
+
+func GetInvitesHandler(s Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		roomID := r.PathValue("roomID")
+
+		if err := validateRoomId(roomID); err != "" {
+			common.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		room, exists := s.GetRoom(roomID)
+		if !exists {
+			common.WriteError(w, http.StatusNotFound, "Chat Room not found.")
+			return
+		}
+
+		claims, ok := r.Context().Value(auth.ParticipantClaimsKey).(*auth.Claims)
+		if !ok || claims == nil {
+			common.WriteError(w, http.StatusInternalServerError, "Invalid authorizaton token.")
+			return
+		}
+
+		room.mu.RLock()
+		if claims.RoomID != room.roomID {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
+			return
+		}
+
+		if !room.getParticipantRole(claims.UserID).Can(common.PermInvite) {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You don't have permission to see the invites of this room.")
+			return
+		}
+
+		resp := &GetInvitesResponse{
+			Invites: room.getInvitesAsSlice(),
+		}
+		room.mu.RUnlock()
+
+		common.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+func AnswerKnockHandler(s Storage, approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		roomID := r.PathValue("roomID")
+
+		if err := validateRoomId(roomID); err != "" {
+			common.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		room, exists := s.GetRoom(roomID)
+		if !exists {
+			common.WriteError(w, http.StatusNotFound, "Chat Room not found.")
+			return
+		}
+
+		claims, ok := r.Context().Value(auth.ParticipantClaimsKey).(*auth.Claims)
+		if !ok || claims == nil {
+			common.WriteError(w, http.StatusInternalServerError, "Invalid authorizaton token.")
+			return
+		}
+
+		room.mu.RLock()
+		if claims.RoomID != room.roomID {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
+			return
+		}
+		role := room.getParticipantRole(claims.UserID)
+		room.mu.RUnlock()
+
+		if !role.Can(common.PermInvite) {
+			common.WriteError(w, http.StatusForbidden, "You don't have permission to answer join requests.")
+			return
+		}
+
+		if err := room.answerKnock(r.PathValue("knockID"), approve); err != nil {
+			writeRoomError(w, err, http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type GetBansResponse struct {
+	Bans []Ban `json:"bans"`
+}
+
+func GetBansHandler(s Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		roomID := r.PathValue("roomID")
+
+		if err := validateRoomId(roomID); err != "" {
+			common.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		room, exists := s.GetRoom(roomID)
+		if !exists {
+			common.WriteError(w, http.StatusNotFound, "Chat Room not found.")
+			return
+		}
+
+		claims, ok := r.Context().Value(auth.ParticipantClaimsKey).(*auth.Claims)
+		if !ok || claims == nil {
+			common.WriteError(w, http.StatusInternalServerError, "Invalid authorizaton token.")
+			return
+		}
+
+		room.mu.RLock()
+		if claims.RoomID != room.roomID {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
+			return
+		}
+
+		if !room.getParticipantRole(claims.UserID).Can(common.PermBan) {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You don't have permission to see the bans of this room.")
+			return
+		}
+
+		resp := &GetBansResponse{
+			Bans: room.getBansAsSlice(),
+		}
+		room.mu.RUnlock()
+
+		common.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+func UnbanParticipantHandler(s Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		roomID := r.PathValue("roomID")
+
+		if err := validateRoomId(roomID); err != "" {
+			common.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		targetID, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+		if err != nil || targetID == 0 {
+			common.WriteError(w, http.StatusBadRequest, "Invalid User Id.")
+			return
+		}
+
+		room, exists := s.GetRoom(roomID)
+		if !exists {
+			common.WriteError(w, http.StatusNotFound, "Chat Room not found.")
+			return
+		}
+
+		claims, ok := r.Context().Value(auth.ParticipantClaimsKey).(*auth.Claims)
+		if !ok || claims == nil {
+			common.WriteError(w, http.StatusInternalServerError, "Invalid authorizaton token.")
+			return
+		}
+
+		room.mu.RLock()
+		if claims.RoomID != room.roomID {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
+			return
+		}
+		role := room.getParticipantRole(claims.UserID)
+		room.mu.RUnlock()
+
+		if !role.Can(common.PermBan) {
+			common.WriteError(w, http.StatusForbidden, "You don't have permission to unban anyone from this room.")
+			return
+		}
+
+		if err := room.unban(uint32(targetID)); err != nil {
+			writeRoomError(w, err, http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type RoleRequest struct {
+	TargetUserID uint32      `json:"userId"`
+	Role         common.Role `json:"role"`
+}
+
+func SetParticipantRoleHandler(s Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 128)
+
+		var req RoleRequest
+
+		roomID := r.PathValue("roomID")
+
+		if err := validateRoomId(roomID); err != "" {
+			common.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			common.WriteError(w, http.StatusBadRequest, "Invalid JSON request body.")
+			return
+		}
+
+		if req.TargetUserID == 0 {
+			common.WriteError(w, http.StatusBadRequest, "User Id is required in the request.")
+			return
+		}
+
+		if req.Role != common.Member && req.Role != common.Moderator {
+			common.WriteError(w, http.StatusBadRequest, "Role must be either member or moderator.")
+			return
+		}
+
+		room, exists := s.GetRoom(roomID)
+		if !exists {
+			common.WriteError(w, http.StatusNotFound, "Chat Room not found.")
+			return
+		}
+
+		claims, ok := r.Context().Value(auth.ParticipantClaimsKey).(*auth.Claims)
+		if !ok || claims == nil {
+			common.WriteError(w, http.StatusInternalServerError, "Invalid authorizaton token.")
+			return
+		}
+
+		room.mu.RLock()
+		if claims.RoomID != room.roomID {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
+			return
+		}
+		role := room.getParticipantRole(claims.UserID)
+		targetRole := room.getParticipantRole(req.TargetUserID)
+		room.mu.RUnlock()
+
+		if !role.Can(common.PermManageRoles) {
+			common.WriteError(w, http.StatusForbidden, "You don't have permission to change roles in this room.")
+			return
+		}
+
+		if claims.UserID == req.TargetUserID {
+			common.WriteError(w, http.StatusBadRequest, "You can't change your own role.")
+			return
+		}
+
+		if targetRole != 0 && !role.Outranks(targetRole) {
+			common.WriteError(w, http.StatusForbidden, "You can't change the role of a participant with the same or a higher role.")
+			return
+		}
+
+		if err := room.setRole(req.TargetUserID, req.Role); err != nil {
+			if errors.Is(err, errSpectatorRole) {
+				common.WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeRoomError(w, err, http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type RenameRequest struct {
+	// TargetUserID is only set when renaming someone else, participants rename themselves without it
+	TargetUserID uint32 `json:"userId,omitempty"`
+	Username     string `json:"username"`
+}
+
+type RenameResponse struct {
+	// Token is only returned when renaming yourself to a new username, others get their new token over WS
+	Token string `json:"token,omitempty"`
+}
+
+func RenameParticipantHandler(s Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 128)
+
+		var req RenameRequest
+
+		roomID := r.PathValue("roomID")
+
+		if err := validateRoomId(roomID); err != "" {
+			common.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			common.WriteError(w, http.StatusBadRequest, "Invalid JSON request body.")
+			return
+		}
+
+		fieldErrors := make(map[string]string, 1) // field name -> error message
+
+		validateUsername(req.Username, fieldErrors)
+
+		if len(fieldErrors) > 0 {
+			common.WriteFieldErrors(w, http.StatusBadRequest, fieldErrors)
+			return
+		}
+
+		room, exists := s.GetRoom(roomID)
+		if !exists {
+			common.WriteError(w, http.StatusNotFound, "Chat Room not found.")
+			return
+		}
+
+		claims, ok := r.Context().Value(auth.ParticipantClaimsKey).(*auth.Claims)
+		if !ok || claims == nil {
+			common.WriteError(w, http.StatusInternalServerError, "Invalid authorizaton token.")
+			return
+		}
+
+		isSelf := req.TargetUserID == 0 || req.TargetUserID == claims.UserID
+		if isSelf {
+			req.TargetUserID = claims.UserID
+		}
+
+		room.mu.RLock()
+		if claims.RoomID != room.roomID {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
+			return
+		}
+		role := room.getParticipantRole(claims.UserID)
+		targetRole := room.getParticipantRole(req.TargetUserID)
+		room.mu.RUnlock()
+
+		if role == 0 {
+			common.WriteError(w, http.StatusForbidden, "You are not in this room.")
+			return
+		}
+
+		if !isSelf {
+			if !role.Can(common.PermRename) {
+				common.WriteError(w, http.StatusForbidden, "You don't have permission to rename other participants.")
+				return
+			}
+
+			if targetRole != 0 && !role.Outranks(targetRole) {
+				common.WriteError(w, http.StatusForbidden, "You can't rename a participant with the same or a higher role.")
+				return
+			}
+		}
+
+		token, err := room.rename(req.TargetUserID, req.Username)
+		if errors.Is(err, errUsernameTaken) {
+			fieldErrors["username"] = err.Error()
+			common.WriteFieldErrors(w, http.StatusBadRequest, fieldErrors)
+			return
+		}
+		if err != nil {
+			writeRoomError(w, err, http.StatusNotFound)
+			return
+		}
+
+		resp := &RenameResponse{}
+		if isSelf {
+			resp.Token = token
+		}
+
+		common.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+type TransferAdminResponse struct {
+	Token string `json:"token"`
+}
+
+func TransferAdminHandler(s Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 128)
+
+		var req UserRequest
+
+		roomID := r.PathValue("roomID")
+
+		if err := validateRoomId(roomID); err != "" {
+			common.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			common.WriteError(w, http.StatusBadRequest, "Invalid JSON request body.")
+			return
+		}
+
+		if req.TargetUserID == 0 {
+			common.WriteError(w, http.StatusBadRequest, "User Id is required in the request.")
+			return
+		}
+
+		room, exists := s.GetRoom(roomID)
+		if !exists {
+			common.WriteError(w, http.StatusNotFound, "Chat Room not found.")
+			return
+		}
+
+		claims, ok := r.Context().Value(auth.ParticipantClaimsKey).(*auth.Claims)
+		if !ok || claims == nil {
+			common.WriteError(w, http.StatusInternalServerError, "Invalid authorizaton token.")
+			return
+		}
+
+		room.mu.RLock()
+		if claims.RoomID != room.roomID {
+			room.mu.RUnlock()
+			common.WriteError(w, http.StatusForbidden, "You do not have access to this room.")
+			return
+		}
+		role := room.getParticipantRole(claims.UserID)
+		room.mu.RUnlock()
+
+		if !role.Can(common.PermTransfer) {
+			common.WriteError(w, http.StatusForbidden, "You don't have permission to transfer the admin role.")
+			return
+		}
+
+		if claims.UserID == req.TargetUserID {
+			common.WriteError(w, http.StatusBadRequest, "You are already the admin of the room.")
+			return
+		}
+
+		token, err := room.transferAdmin(claims.UserID, req.TargetUserID)
+		if err != nil {
+			if errors.Is(err, errSpectatorRole) {
+				common.WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeRoomError(w, err, http.StatusNotFound)
+			return
+		}
+
+		common.WriteJSON(w, http.StatusOK, &TransferAdminResponse{Token: token})
+	}
+}
+
+var WaitForTestClientWSSetup chan struct{}
+
+func waitForTestClientWS() {
+	if WaitForTestClientWSSetup != nil {
+		<-WaitForTestClientWSSetup
+		time.Sleep(1 * time.Millisecond)
+	}
+}
+
+func RoomWSHandler(s Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		_, errMsg := middleware.ValidateOriginHost(origin)
+
+		conn, _, _, err := ws.HTTPUpgrader{
+			Timeout: 2 * time.Second,
+		}.Upgrade(r, w)
+		if err != nil {
+			return
+		}
+
+		waitForTestClientWS()
+
+		if errMsg != "" {
+			wsutil.WriteServerMessage(conn, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "invalid-origin"))
+			conn.Close()
+			return
+		}
+
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			wsutil.WriteServerMessage(conn, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "token-missing"))
+			conn.Close()
+			return
+		}
+
+		claims, err := auth.ValidateToken[auth.Claims](token)
+		if err != nil {
+			wsutil.WriteServerMessage(conn, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "token-invalid"))
+			conn.Close()
+			return
+		}
+
+		room, exists := s.GetRoom(claims.RoomID)
+		if !exists {
+			wsutil.WriteServerMessage(conn, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "room-not-exists"))
+			conn.Close()
+			return
+		}
+
+		room.addWSConn(conn, claims.UserID)
+	}
+}
+
+func createInviteLink(origin, roomID, secretKey string, exp int64) (string, error) {
+	inviteClaims := auth.InviteClaims{
+		RoomID:    roomID,
+		SecretKey: secretKey,
+		Exp:       exp,
+	}
+
+	inviteToken, err := auth.CreateToken(inviteClaims)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/join#invite=%s", origin, inviteToken), nil
+}
+
+// writeRoomError writes the error of a room operation,
+// a closed room is reported with 410 and any other error with the given status
+func writeRoomError(w http.ResponseWriter, err error, status int) {
+	if errors.Is(err, errRoomClosed) {
+		status = http.StatusGone
+	}
+	common.WriteError(w, status, err.Error())
+}
+
+func validateUsername(username string, fieldErrors map[string]string) {
+	if username == "" {
+		fieldErrors["username"] = "Username cannot be empty."
+		return
+	}
+
+	if strings.Contains(username, " ") {
+		fieldErrors["username"] = "Username cannot contain spaces."
+		return
+	}
+
+	runeCount := len([]rune(username))
+	if runeCount < 3 || runeCount > 15 {
+		fieldErrors["username"] = "Username must be between 3 and 15 characters."
+	}
+}
+
+// empty password is valid, it means the room has no password
+func validatePassword(password string, fieldErrors map[string]string) {
+	if password == "" {
+		return
+	}
+
+	runeCount := len([]rune(password))
+	if runeCount < 4 || runeCount > 64 {
+		fieldErrors["password"] = "Password must be between 4 and 64 characters."
 	}
 }
 
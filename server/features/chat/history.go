@@ -0,0 +1,117 @@
+package chat
+
+import (
+	"encoding/json"
+
+	"kseli/common"
+)
+
+// msgHistory keeps the most recent chat messages of a room with the history enabled.
+// The content is the ciphertext sent by the clients, the history only lives in memory and is dropped with the room.
+type msgHistory struct {
+	msgs  []ChatMsg
+	start int // index of the oldest message
+	count int
+}
+
+func newMsgHistory(size uint16) *msgHistory {
+	return &msgHistory{msgs: make([]ChatMsg, size)}
+}
+
+// add stores the message, overwriting the oldest one once the history is full
+func (h *msgHistory) add(msg ChatMsg) {
+	if h.count < len(h.msgs) {
+		h.msgs[(h.start+h.count)%len(h.msgs)] = msg
+		h.count++
+		return
+	}
+
+	h.msgs[h.start] = msg
+	h.start = (h.start + 1) % len(h.msgs)
+}
+
+// last returns up to n of the most recent messages, oldest first
+func (h *msgHistory) last(n int) []ChatMsg {
+	n = min(n, h.count)
+	msgs := make([]ChatMsg, 0, n)
+
+	for i := h.count - n; i < h.count; i++ {
+		msgs = append(msgs, h.msgs[(h.start+i)%len(h.msgs)])
+	}
+
+	return msgs
+}
+
+// after returns the kept messages with a sequence number above seq, oldest first.
+// truncated is true when some of the messages after seq were already overwritten.
+func (h *msgHistory) after(seq uint64) (msgs []ChatMsg, truncated bool) {
+	if h.count == 0 {
+		return []ChatMsg{}, false
+	}
+
+	oldest := h.msgs[h.start].Seq
+	// a seq past the newest message is from a client that is ahead of the room, it gets nothing
+	seq = min(seq, oldest+uint64(h.count)-1)
+	if seq+1 < oldest {
+		return h.last(h.count), true
+	}
+
+	// sequence numbers in the history have no gaps
+	return h.last(max(h.count-int(seq+1-oldest), 0)), false
+}
+
+type HistoryRequest struct {
+	// AfterSeq is the last sequence number the client has, used to resume after a reconnect
+	AfterSeq uint64 `json:"afterSeq,omitempty"`
+	// Limit is how many of the most recent messages a client without any messages wants
+	Limit uint16 `json:"limit,omitempty"`
+}
+
+type HistoryMsg struct {
+	Messages  []ChatMsg `json:"messages"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// handleHistoryCommand sends the requested part of the history to the sender.
+// It is queued under the same lock chat messages are broadcast with,
+// so no message falls between the history and the live messages that follow it.
+func (r *Room) handleHistoryCommand(id uint32, _ common.Role, data json.RawMessage) (AckMsg, string) {
+	var req HistoryRequest
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return AckMsg{}, "Invalid history request."
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkOpen(); err != nil {
+		return AckMsg{}, err.Error()
+	}
+
+	if r.history == nil {
+		return AckMsg{}, "This room doesn't keep a message history."
+	}
+
+	p, exists := r.getParticipantByID(id)
+	if !exists {
+		return AckMsg{}, ""
+	}
+
+	var resp HistoryMsg
+	if req.AfterSeq > 0 {
+		resp.Messages, resp.Truncated = r.history.after(req.AfterSeq)
+	} else {
+		limit := len(r.history.msgs)
+		if req.Limit > 0 {
+			limit = int(req.Limit)
+		}
+		resp.Messages = r.history.last(limit)
+	}
+
+	p.send(encodeWSMessage("history", resp))
+
+	return AckMsg{}, ""
+}
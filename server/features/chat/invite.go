@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"fmt"
+	"time"
+
+	"kseli/auth"
+	"kseli/config"
+)
+
+// Invite is an additional invite link minted by an admin on top of the main room invite link
+type Invite struct {
+	ID        string `json:"id"`
+	Label     string `json:"label,omitempty"`
+	MaxUses   uint16 `json:"maxUses,omitempty"` // 0 means unlimited
+	Uses      uint16 `json:"uses"`
+	ExpiresAt int64  `json:"expiresAt"`
+	// spectator invites let people join as read-only spectators
+	Spectator bool `json:"spectator,omitempty"`
+	// followsRoom invites were minted without a lifetime, their expiry moves with the room's
+	followsRoom bool
+}
+
+// createInvite mints a new invite token for the room. The invite can not outlive the room,
+// a zero lifetime means it expires together with the room, even after the room is extended.
+// Those tokens are signed up to the maximum room lifetime, the invite expiry is enforced by checkInvite.
+func (r *Room) createInvite(origin, label string, maxUses uint16, lifetime time.Duration, spectator bool) (*Invite, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkOpen(); err != nil {
+		return nil, "", err
+	}
+
+	expiresAt := r.expiresAt
+	tokenExp := r.createdAt + int64(config.MaxRoomLifetime.Seconds())
+	if lifetime > 0 {
+		expiresAt = min(time.Now().Add(lifetime).Unix(), r.expiresAt)
+		tokenExp = expiresAt
+	}
+
+	inv := &Invite{
+		ID:          generateRandomString(6),
+		Label:       label,
+		MaxUses:     maxUses,
+		ExpiresAt:   expiresAt,
+		Spectator:   spectator,
+		followsRoom: lifetime <= 0,
+	}
+
+	inviteClaims := auth.InviteClaims{
+		RoomID:    r.roomID,
+		SecretKey: r.secretKey,
+		InviteID:  inv.ID,
+		Spectator: spectator,
+		Exp:       tokenExp,
+	}
+
+	inviteToken, err := auth.CreateToken(inviteClaims)
+	if err != nil {
+		return nil, "", err
+	}
+
+	r.invites[inv.ID] = inv
+
+	return inv, fmt.Sprintf("%s/join#invite=%s", origin, inviteToken), nil
+}
+
+// make sure caller locks room for reading
+// checkInvite returns an error if the invite can't be used to join, the main room invite has no ID
+func (r *Room) checkInvite(inviteID string) error {
+	if inviteID == "" {
+		return nil
+	}
+
+	inv, exists := r.invites[inviteID]
+	if !exists {
+		return errInvalidInvite
+	}
+
+	if inv.ExpiresAt <= time.Now().Unix() {
+		return errInviteExpired
+	}
+
+	if inv.MaxUses != 0 && inv.Uses >= inv.MaxUses {
+		return errInviteUsedUp
+	}
+
+	return nil
+}
+
+// make sure caller locks room for rw
+// extendInvites moves the expiry of the invites minted without a lifetime to the new room expiry
+func (r *Room) extendInvites(expiresAt int64) {
+	for _, inv := range r.invites {
+		if inv.followsRoom {
+			inv.ExpiresAt = expiresAt
+		}
+	}
+}
+
+// make sure caller locks room for rw
+func (r *Room) consumeInvite(inviteID string) {
+	if inv, exists := r.invites[inviteID]; exists {
+		inv.Uses++
+	}
+}
+
+// make sure caller locks room for reading
+func (r *Room) getInvitesAsSlice() []Invite {
+	invites := make([]Invite, 0, len(r.invites))
+
+	for _, inv := range r.invites {
+		invites = append(invites, *inv)
+	}
+
+	return invites
+}
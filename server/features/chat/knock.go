@@ -0,0 +1,136 @@
+package chat
+
+import (
+	"errors"
+	"time"
+
+	"kseli/common"
+	"kseli/config"
+)
+
+type knockStatus uint8
+
+const (
+	knockPending knockStatus = iota
+	knockApproved
+	knockDenied
+)
+
+// Knock is a join request waiting for an admin answer in rooms with knock mode enabled
+type Knock struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	RequestedAt int64  `json:"requestedAt"`
+	sessionID   string
+	status      knockStatus
+	// timeout removes the knock if the admin doesn't answer or the joiner doesn't come back for the answer
+	timeout *time.Timer
+}
+
+type KnockAnswerMsg struct {
+	ID       string `json:"id"`
+	Approved bool   `json:"approved"`
+}
+
+type KnockCancelMsg struct {
+	ID string `json:"id"`
+}
+
+// make sure caller locks room for rw
+// knockForJoin returns the knock of the joiner, a new pending one is created if there is none yet.
+// Answered knocks are removed, the joiner can be admitted if the returned knock is approved.
+func (r *Room) knockForJoin(sessionID, username string) (*Knock, bool) {
+	if k, exists := r.knocks[sessionID]; exists {
+		// an answer is only valid for the username the admin saw
+		if k.Username == username || k.status == knockDenied {
+			if k.status != knockPending {
+				k.timeout.Stop()
+				delete(r.knocks, sessionID)
+			}
+			return k, false
+		}
+
+		k.timeout.Stop()
+		delete(r.knocks, sessionID)
+		go r.broadcastKnockCancel(k.ID)
+	}
+
+	k := &Knock{
+		ID:          generateRandomString(6),
+		Username:    username,
+		RequestedAt: time.Now().Unix(),
+		sessionID:   sessionID,
+		status:      knockPending,
+	}
+
+	k.timeout = time.AfterFunc(config.KnockTimeout, func() {
+		r.mu.Lock()
+		current, exists := r.knocks[sessionID]
+		if !exists || current != k {
+			r.mu.Unlock()
+			return
+		}
+		delete(r.knocks, sessionID)
+		r.mu.Unlock()
+
+		r.broadcastKnockCancel(k.ID)
+	})
+
+	r.knocks[sessionID] = k
+
+	return k, true
+}
+
+// answerKnock approves or denies a pending knock, the joiner gets the answer on their next join request
+func (r *Room) answerKnock(knockID string, approve bool) error {
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+
+	var knock *Knock
+	for _, k := range r.knocks {
+		if k.ID == knockID && k.status == knockPending {
+			knock = k
+			break
+		}
+	}
+
+	if knock == nil {
+		r.mu.Unlock()
+		return errors.New("Join request not found.")
+	}
+
+	if approve {
+		knock.status = knockApproved
+	} else {
+		knock.status = knockDenied
+	}
+	r.mu.Unlock()
+
+	r.broadcastToPermitted(encodeWSMessage("knock-answered", KnockAnswerMsg{ID: knockID, Approved: approve}), common.PermInvite, 0)
+
+	return nil
+}
+
+// make sure caller locks room for reading
+func (r *Room) getKnocksAsSlice() []Knock {
+	knocks := make([]Knock, 0, len(r.knocks))
+
+	for _, k := range r.knocks {
+		if k.status == knockPending {
+			knocks = append(knocks, *k)
+		}
+	}
+
+	return knocks
+}
+
+func (r *Room) broadcastKnock(k *Knock) {
+	r.broadcastToPermitted(encodeWSMessage("knock", k), common.PermInvite, 0)
+}
+
+func (r *Room) broadcastKnockCancel(knockID string) {
+	r.broadcastToPermitted(encodeWSMessage("knock-cancel", KnockCancelMsg{ID: knockID}), common.PermInvite, 0)
+}
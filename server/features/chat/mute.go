@@ -0,0 +1,103 @@
+package chat
+
+import (
+	"fmt"
+	"time"
+)
+
+type MuteMsg struct {
+	ID uint32 `json:"id"`
+	// MutedUntil is the unix time the mute ends at, 0 if it lasts until the participant is unmuted
+	MutedUntil int64 `json:"mutedUntil,omitempty"`
+}
+
+// mute keeps the participant in the room but their chat messages are no longer relayed.
+// A duration of 0 mutes them until unmute is called, muting again replaces the previous duration.
+func (r *Room) mute(pID uint32, duration time.Duration) error {
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+
+	p, exists := r.getParticipantByID(pID)
+	if !exists {
+		r.mu.Unlock()
+		return fmt.Errorf("Participant with ID '%d' not found in room", pID)
+	}
+
+	if p.muteTimer != nil {
+		p.muteTimer.Stop()
+		p.muteTimer = nil
+	}
+
+	p.muted = true
+	p.mutedUntil = 0
+	if duration > 0 {
+		p.mutedUntil = time.Now().Add(duration).Unix()
+		p.muteTimer = time.AfterFunc(duration, func() {
+			r.unmute(pID)
+		})
+	}
+	mutedUntil := p.mutedUntil
+	wasTyping := r.clearTyping(p)
+	r.mu.Unlock()
+
+	if wasTyping {
+		r.broadcastTyping(pID, false)
+	}
+	r.broadcastMessage(encodeWSMessage("muted", MuteMsg{ID: pID, MutedUntil: mutedUntil}))
+
+	return nil
+}
+
+func (r *Room) unmute(pID uint32) error {
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+
+	p, exists := r.getParticipantByID(pID)
+	if !exists || !p.muted {
+		r.mu.Unlock()
+		return fmt.Errorf("Muted participant with ID '%d' not found in room", pID)
+	}
+
+	if p.muteTimer != nil {
+		p.muteTimer.Stop()
+		p.muteTimer = nil
+	}
+	p.muted = false
+	p.mutedUntil = 0
+	r.mu.Unlock()
+
+	r.broadcastMessage(encodeWSMessage("unmuted", MuteMsg{ID: pID}))
+
+	return nil
+}
+
+// muteError is returned for the messages of a muted participant, until is 0 for mutes without a duration
+type muteError struct {
+	until int64
+}
+
+func (e *muteError) Error() string {
+	if e.until == 0 {
+		return "You are muted."
+	}
+	return fmt.Sprintf("You are muted, try again in %d seconds.", max(e.until-time.Now().Unix(), 1))
+}
+
+// checkMuted returns a muteError if the participant is muted, the caller then drops their message
+func (r *Room) checkMuted(id uint32) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, exists := r.getParticipantByID(id)
+	if !exists || !p.muted {
+		return nil
+	}
+
+	return &muteError{until: p.mutedUntil}
+}
@@ -11,19 +11,55 @@ import (
 type Participant struct {
 	mu        sync.Mutex
 	sessionID string
-	id        uint8
+	id        uint32
 	username  string
 	role      common.Role
+	inviteID  string
+	tokenExp  int64
+	joinedAt  time.Time
 	wsConn    net.Conn
-	msgQueue  chan []byte
+	msgQueue  chan queuedMsg
 	// wsTimeout is a timer used to clean up a participant that never establishes a WS connection
 	// used in room.go in the "join" method
 	// stopped in ws.go in the "addWSConn" method
 	wsTimeout *time.Timer
+	// muted participants stay connected but their chat messages are dropped in handleRead,
+	// muteTimer unmutes them when the mute was given a duration
+	muted      bool
+	mutedUntil int64
+	muteTimer  *time.Timer
+	// chat message rate limiting, guarded by mu
+	limiter   tokenBucket
+	lastMsgAt time.Time
+	// typing indicator, typingTimer clears it when the client stops refreshing it
+	typing      bool
+	typingAt    time.Time
+	typingTimer *time.Timer
+	// sequence number of the last chat message the participant has read
+	readSeq uint64
+}
+
+// queuedMsg is a message waiting in msgQueue, seq is set for chat messages
+// of other participants so handleWrite can report the delivery
+type queuedMsg struct {
+	data []byte
+	seq  uint64
+}
+
+type Ban struct {
+	ID       uint32 `json:"id"`
+	Username string `json:"username"`
+	BannedAt int64  `json:"bannedAt"`
+	// ExpiresAt is 0 for bans that last as long as the room
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+	// expiry lifts a time-limited ban
+	expiry *time.Timer
 }
 
 type ParticipantView struct {
-	ID       uint8       `json:"id"`
+	ID       uint32      `json:"id"`
 	Username string      `json:"username,omitempty"`
 	Role     common.Role `json:"role,omitempty"`
+	InviteID string      `json:"inviteId,omitempty"`
+	Muted    bool        `json:"muted,omitempty"`
 }
@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"time"
+
+	"kseli/auth"
+	"kseli/config"
+)
+
+// make sure caller locks room for reading
+func (r *Room) hasPassword() bool {
+	return r.passwordHash != nil
+}
+
+// make sure caller locks room for reading
+// hasPendingJoin tells if the session has a knock or is queued, their first join request got past the password
+func (r *Room) hasPendingJoin(sessionID string) bool {
+	if _, exists := r.knocks[sessionID]; exists {
+		return true
+	}
+
+	w, _ := r.getWaiter(sessionID)
+	return w != nil
+}
+
+// checkPassword verifies the room password, wrong attempts are counted per room within config.PasswordAttemptWindow
+// and once config.MaxPasswordAttempts is reached joining is blocked for config.PasswordLockout.
+// A correct password doesn't reset the count, guesses can't hide between legitimate joins.
+// retryAfter is set when the attempt was refused because of the block.
+func (r *Room) checkPassword(password string) (ok bool, retryAfter time.Duration) {
+	r.mu.Lock()
+	if r.checkOpen() != nil {
+		r.mu.Unlock()
+		return false, 0
+	}
+
+	now := time.Now()
+	if now.Before(r.passwordBlockedUntil) {
+		r.mu.Unlock()
+		return false, r.passwordBlockedUntil.Sub(now)
+	}
+
+	if now.Sub(r.passwordWindowStart) >= config.PasswordAttemptWindow {
+		r.passwordAttempts = 0
+		r.passwordWindowStart = now
+	}
+
+	// the attempt is counted before the slow hash so parallel guesses can't go over the limit,
+	// attempts still being checked hold their place until they turn out right
+	if r.passwordAttempts >= config.MaxPasswordAttempts {
+		r.mu.Unlock()
+		return false, config.PasswordLockout
+	}
+	r.passwordAttempts++
+	windowStart := r.passwordWindowStart
+	hash := r.passwordHash
+	r.mu.Unlock()
+
+	ok = auth.VerifyPassword(hash, password)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the window could have been reset by a block or a new password in the meantime
+	if !r.passwordWindowStart.Equal(windowStart) {
+		return ok, 0
+	}
+
+	if ok {
+		r.passwordAttempts--
+	} else if r.passwordAttempts >= config.MaxPasswordAttempts {
+		r.passwordAttempts = 0
+		r.passwordWindowStart = time.Time{}
+		r.passwordBlockedUntil = time.Now().Add(config.PasswordLockout)
+	}
+
+	return ok, 0
+}
+
+// setPassword replaces the room password, an empty password removes it
+func (r *Room) setPassword(password string) error {
+	var hash []byte
+
+	if password != "" {
+		var err error
+		if hash, err = auth.HashPassword(password); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+
+	r.passwordHash = hash
+	r.passwordAttempts = 0
+	r.passwordWindowStart = time.Time{}
+	r.passwordBlockedUntil = time.Time{}
+	r.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package chat
+
+import (
+	"fmt"
+	"time"
+
+	"kseli/common"
+	"kseli/config"
+)
+
+const maxSlowModeSeconds = 300
+
+type RateLimitMsg struct {
+	// RetryAfterMs is how long the sender has to wait before the next message is relayed
+	RetryAfterMs int64 `json:"retryAfterMs"`
+}
+
+type SlowModeMsg struct {
+	Seconds uint16 `json:"seconds"`
+}
+
+// tokenBucket holds up to burst tokens and gets a new one every refill interval, every message takes one
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take removes a token if there is one, otherwise it returns how long until the next token is available
+func (b *tokenBucket) take(now time.Time, burst uint16, refill time.Duration) time.Duration {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = min(float64(burst), b.tokens+float64(now.Sub(b.last))/float64(refill))
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) * float64(refill))
+}
+
+// checkRateLimit decides if a chat message of the participant can be relayed.
+// Every participant has a token bucket, on top of that slow mode allows one message per interval
+// to everyone except the participants that can change slow mode.
+func (r *Room) checkRateLimit(id uint32) (time.Duration, bool) {
+	r.mu.RLock()
+	p, exists := r.getParticipantByID(id)
+	if !exists {
+		r.mu.RUnlock()
+		return 0, false
+	}
+	slowMode := r.slowMode
+	if p.role.Can(common.PermSlowMode) {
+		slowMode = 0
+	}
+	r.mu.RUnlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if slowMode > 0 && !p.lastMsgAt.IsZero() {
+		if wait := p.lastMsgAt.Add(slowMode).Sub(now); wait > 0 {
+			return wait, true
+		}
+	}
+
+	if wait := p.limiter.take(now, config.MessageBurst, config.MessageRefill); wait > 0 {
+		return wait, true
+	}
+
+	p.lastMsgAt = now
+
+	return 0, false
+}
+
+// rateLimitError is returned for a chat message sent before the rate limit or slow mode allows the next one
+type rateLimitError struct {
+	wait time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("You are sending messages too fast, try again in %d seconds.", int((e.wait+time.Second-1)/time.Second))
+}
+
+func (r *Room) setSlowMode(seconds uint16) error {
+	slowMode := time.Duration(seconds) * time.Second
+
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+
+	if r.slowMode == slowMode {
+		r.mu.Unlock()
+		return nil
+	}
+	r.slowMode = slowMode
+	r.mu.Unlock()
+
+	r.broadcastMessage(encodeWSMessage("slow-mode-changed", SlowModeMsg{Seconds: seconds}))
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package chat
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	"kseli/common"
+	"kseli/config"
+)
+
+// ReceiptMsg tells the sender of a chat message how many of the others got it and read it so far
+type ReceiptMsg struct {
+	MsgID     string `json:"msgId"`
+	Seq       uint64 `json:"seq"`
+	Delivered int    `json:"delivered"`
+	Read      int    `json:"read"`
+}
+
+// ReceiptsMsg holds the receipts of every message of the sender that changed since the last one, in sequence order
+type ReceiptsMsg struct {
+	Receipts []ReceiptMsg `json:"receipts"`
+}
+
+type ReadRequest struct {
+	// Seq is the sequence number of the last message the client has read
+	Seq uint64 `json:"seq"`
+}
+
+// msgReceipts collects the receipts of one chat message, keyed by the participant ID of the recipient.
+// Only recipients the message was queued to can report it delivered or read.
+type msgReceipts struct {
+	senderID  uint32
+	msgID     string
+	queued    map[uint32]struct{}
+	delivered map[uint32]struct{}
+	read      map[uint32]struct{}
+}
+
+// receiptTracker collects the receipts of the chat messages of a room. It has its own lock since every
+// recipient reports deliveries from its write loop, the room lock would serialize all of them.
+// Changes are not sent right away, they are flushed to the senders once per config.ReceiptFlushInterval.
+type receiptTracker struct {
+	mu    sync.Mutex
+	msgs  map[uint64]*msgReceipts // key message seq, nil once the room is closed
+	dirty map[uint64]struct{}     // messages with receipts the sender wasn't told about yet
+	flush *time.Timer             // nil when no flush is pending
+	// every seq below oldest was evicted, plain text messages leave gaps in the tracked seqs
+	oldest uint64
+}
+
+func newReceiptTracker() *receiptTracker {
+	return &receiptTracker{
+		msgs:  make(map[uint64]*msgReceipts),
+		dirty: make(map[uint64]struct{}),
+	}
+}
+
+// make sure caller locks room for rw and the receipt tracker
+// trackReceipts starts collecting receipts for a new chat message, the caller adds the recipients it was queued to.
+// Only the receipts of the last config.ReceiptWindow messages are kept.
+func (r *Room) trackReceipts(meta MsgMeta, senderID uint32) *msgReceipts {
+	rt := r.receipts
+	rc := &msgReceipts{
+		senderID:  senderID,
+		msgID:     meta.MsgID,
+		queued:    make(map[uint32]struct{}),
+		delivered: make(map[uint32]struct{}),
+		read:      make(map[uint32]struct{}),
+	}
+	rt.msgs[meta.Seq] = rc
+
+	if window := uint64(config.ReceiptWindow); meta.Seq > window {
+		for ; rt.oldest <= meta.Seq-window; rt.oldest++ {
+			delete(rt.msgs, rt.oldest)
+			delete(rt.dirty, rt.oldest)
+		}
+	}
+
+	return rc
+}
+
+// markDelivered is called by handleWrite once the chat message was written to the socket of the recipient
+func (r *Room) markDelivered(seq uint64, recipientID uint32) {
+	rt := r.receipts
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rc, exists := rt.msgs[seq]
+	if !exists {
+		return
+	}
+
+	if _, delivered := rc.delivered[recipientID]; delivered {
+		return
+	}
+	rc.delivered[recipientID] = struct{}{}
+
+	r.markDirty(seq)
+}
+
+// markRead marks every message of the others up to seq as read by the participant
+func (r *Room) markRead(id uint32, seq uint64) error {
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+
+	p, exists := r.getParticipantByID(id)
+	if !exists {
+		r.mu.Unlock()
+		return nil
+	}
+
+	seq = min(seq, r.msgSeq)
+	if seq <= p.readSeq {
+		r.mu.Unlock()
+		return nil
+	}
+
+	from := p.readSeq + 1
+	if window := uint64(config.ReceiptWindow); r.msgSeq > window {
+		from = max(from, r.msgSeq-window+1)
+	}
+	p.readSeq = seq
+	r.mu.Unlock()
+
+	rt := r.receipts
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	for s := from; s <= seq; s++ {
+		rc, exists := rt.msgs[s]
+		if !exists || rc.senderID == id {
+			continue
+		}
+
+		if _, queued := rc.queued[id]; !queued {
+			continue
+		}
+
+		if _, read := rc.read[id]; read {
+			continue
+		}
+		rc.read[id] = struct{}{}
+		// the read can arrive before handleWrite reports the delivery
+		rc.delivered[id] = struct{}{}
+
+		r.markDirty(s)
+	}
+
+	return nil
+}
+
+// make sure caller locks the receipt tracker
+// markDirty remembers the message for the next flush and schedules one if none is pending
+func (r *Room) markDirty(seq uint64) {
+	rt := r.receipts
+	rt.dirty[seq] = struct{}{}
+
+	if rt.flush == nil {
+		rt.flush = time.AfterFunc(config.ReceiptFlushInterval, r.flushReceipts)
+	}
+}
+
+// flushReceipts sends every sender one message with the receipts that changed since the last flush
+func (r *Room) flushReceipts() {
+	rt := r.receipts
+	rt.mu.Lock()
+	if rt.msgs == nil {
+		rt.mu.Unlock()
+		return
+	}
+
+	bySender := make(map[uint32][]ReceiptMsg)
+	for seq := range rt.dirty {
+		rc, exists := rt.msgs[seq]
+		if !exists {
+			continue
+		}
+
+		bySender[rc.senderID] = append(bySender[rc.senderID], ReceiptMsg{
+			MsgID:     rc.msgID,
+			Seq:       seq,
+			Delivered: len(rc.delivered),
+			Read:      len(rc.read),
+		})
+	}
+	clear(rt.dirty)
+	rt.flush = nil
+	rt.mu.Unlock()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.checkOpen() != nil {
+		return
+	}
+
+	for senderID, receipts := range bySender {
+		sender, exists := r.getParticipantByID(senderID)
+		if !exists {
+			continue
+		}
+
+		slices.SortFunc(receipts, func(a, b ReceiptMsg) int { return cmp.Compare(a.Seq, b.Seq) })
+		sender.send(encodeWSMessage("receipts", ReceiptsMsg{Receipts: receipts}))
+	}
+}
+
+// make sure caller locks room for rw
+// stopReceipts drops the receipts and a pending flush when the room is closed
+func (r *Room) stopReceipts() {
+	rt := r.receipts
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.flush != nil {
+		rt.flush.Stop()
+		rt.flush = nil
+	}
+	rt.msgs = nil
+	rt.dirty = nil
+}
+
+func (r *Room) handleReadCommand(id uint32, _ common.Role, data json.RawMessage) (AckMsg, string) {
+	var req ReadRequest
+
+	if err := json.Unmarshal(data, &req); err != nil || req.Seq == 0 {
+		return AckMsg{}, "Sequence number is required in the command."
+	}
+
+	if err := r.markRead(id, req.Seq); err != nil {
+		return AckMsg{}, err.Error()
+	}
+
+	return AckMsg{}, ""
+}
@@ -3,25 +3,62 @@ package chat
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	"kseli/auth"
 	"kseli/common"
+	"kseli/config"
 )
 
+type roomState uint8
+
+const (
+	roomOpen roomState = iota
+	// Close took the room down but the WS connections are still being closed
+	roomClosing
+	roomClosed
+)
+
+// errRoomClosed is returned by every operation on a room that is closing or closed
+var errRoomClosed = errors.New("Chat Room has been closed.")
+
 type Room struct {
 	mu                 sync.RWMutex
-	nextParticipantID  uint8
-	maxParticipants    uint8
+	state              roomState
+	nextParticipantID  uint32
+	maxParticipants    uint16
 	roomID             string
 	secretKey          string
 	inviteLink         string
 	participants       map[string]*Participant // key sessionID
-	bannedParticipants map[string]struct{}     // key sessionID
+	participantsByID   map[uint32]*Participant // key participant ID
+	usernames          map[string]*Participant // key username
+	bannedParticipants map[string]*Ban         // key sessionID
+	invites            map[string]*Invite      // key invite ID
+	knocks             map[string]*Knock       // key sessionID
+	waiters            []*Waiter               // in the order they were queued
 	onClose            func(roomID string)
 	onExpire           *time.Timer
+	autoPromote        bool
+	knock              bool
+	waitlist           bool
+	locked             bool
+	spectatorCount     int
+	slowMode           time.Duration
+	msgSeq             uint64      // sequence number of the last chat message
+	history            *msgHistory // nil when the room keeps no history
+	receipts           *receiptTracker
+	createdAt          int64
 	expiresAt          int64
+	// salted hash of the optional room password, nil when the room has none
+	passwordHash []byte
+	// wrong password attempts since passwordWindowStart
+	passwordAttempts     uint16
+	passwordWindowStart  time.Time
+	passwordBlockedUntil time.Time
 }
 
 type Storage interface {
@@ -32,132 +69,635 @@ type Storage interface {
 }
 
 // make sure caller locks room for reading
-func (r *Room) getParticipantByID(ID uint8) (*Participant, bool) {
-	for _, p := range r.participants {
-		if ID == p.id {
-			return p, true
-		}
+// checkOpen has to pass before the room is changed, the room maps are nil once it is closing
+func (r *Room) checkOpen() error {
+	if r.state != roomOpen {
+		return errRoomClosed
+	}
+	return nil
+}
+
+// make sure caller locks room for reading
+func (r *Room) getParticipantByID(ID uint32) (*Participant, bool) {
+	p, exists := r.participantsByID[ID]
+	return p, exists
+}
+
+// make sure caller locks room for reading
+// getParticipantRole returns the current role of the participant or 0 if they are not in the room.
+// Role in the claims can be outdated since the admin role can be handed over.
+func (r *Room) getParticipantRole(ID uint32) common.Role {
+	if p, exists := r.getParticipantByID(ID); exists {
+		return p.role
 	}
 
-	return nil, false
+	return 0
 }
 
 // make sure caller locks room for reading
-func (r *Room) getParticipantByUsername(username string) (*Participant, bool) {
+// getLongestConnected returns the connected participant that joined first, excluding the given ID and spectators
+func (r *Room) getLongestConnected(excludeID uint32) *Participant {
+	var longest *Participant
+
 	for _, p := range r.participants {
-		if username == p.username {
-			return p, true
+		if p.id == excludeID || p.wsConn == nil || p.role == common.Spectator {
+			continue
+		}
+
+		if longest == nil || p.joinedAt.Before(longest.joinedAt) {
+			longest = p
 		}
 	}
 
-	return nil, false
+	return longest
 }
 
 // make sure caller locks room for reading
-func (r *Room) getParticipantsAsSlice() []ParticipantView {
-	pSlice := make([]ParticipantView, 0, len(r.participants))
+// withInvites adds the invite each participant joined with, it is meant for participants that can invite.
+// Spectators are returned separately.
+func (r *Room) getParticipantsAsSlice(withInvites bool) ([]ParticipantView, []ParticipantView) {
+	pSlice := make([]ParticipantView, 0, r.memberCount())
+	sSlice := make([]ParticipantView, 0, r.spectatorCount)
 
 	for _, p := range r.participants {
 		pView := ParticipantView{
 			ID:       p.id,
 			Username: p.username,
 			Role:     p.role,
+			Muted:    p.muted,
+		}
+		if withInvites {
+			pView.InviteID = p.inviteID
+		}
+		if p.role == common.Spectator {
+			sSlice = append(sSlice, pView)
+		} else {
+			pSlice = append(pSlice, pView)
 		}
-		pSlice = append(pSlice, pView)
 	}
 
-	return pSlice
+	return pSlice, sSlice
 }
 
 // make sure caller locks room for reading
 func (r *Room) isUsernameTaken(username string) bool {
-	for _, p := range r.participants {
-		if username == p.username {
-			return true
-		}
+	_, taken := r.usernames[username]
+	return taken
+}
+
+// make sure caller locks room for rw
+// addParticipant and removeParticipant keep the participant lookup maps in sync
+func (r *Room) addParticipant(p *Participant) {
+	r.participants[p.sessionID] = p
+	r.participantsByID[p.id] = p
+	r.usernames[p.username] = p
+	if p.role == common.Spectator {
+		r.spectatorCount++
+	}
+}
+
+// make sure caller locks room for rw
+// removeParticipant also hands the freed slot to the next waiter, if there is one
+func (r *Room) removeParticipant(p *Participant) {
+	if p.wsTimeout != nil {
+		p.wsTimeout.Stop()
+		p.wsTimeout = nil
+	}
+	if p.muteTimer != nil {
+		p.muteTimer.Stop()
+		p.muteTimer = nil
 	}
+	r.clearTyping(p)
+	// only a participant that is still in the room holds a spectator slot
+	if current, exists := r.getParticipantByID(p.id); exists && current == p && p.role == common.Spectator {
+		r.spectatorCount--
+	}
+	delete(r.participants, p.sessionID)
+	delete(r.participantsByID, p.id)
+	delete(r.usernames, p.username)
 
-	return false
+	r.admitWaiters()
 }
 
 // make sure caller locks room for rw
 func (r *Room) join(p *Participant) {
-	r.participants[p.sessionID] = p
+	p.joinedAt = time.Now()
+	r.addParticipant(p)
 
 	// Start 10s timeout to wait for WebSocket connection
 	p.wsTimeout = time.AfterFunc(10*time.Second, func() {
-		var shouldClose bool
+		var isAdmin bool
 
 		r.mu.Lock()
+		if r.checkOpen() != nil {
+			r.mu.Unlock()
+			return
+		}
+
+		// The participant could have been removed before the lock was taken,
+		// their session and username might belong to someone else by now
+		if current, exists := r.getParticipantByID(p.id); !exists || current != p {
+			r.mu.Unlock()
+			return
+		}
+
 		// If WS is still not connected, remove the participant
 		if p.wsConn == nil {
-			delete(r.participants, p.sessionID)
 			if p.role == common.Admin {
-				shouldClose = true
+				isAdmin = true
+			} else {
+				r.removeParticipant(p)
 			}
 		}
 		r.mu.Unlock()
 
-		if shouldClose {
-			r.Close(false)
+		if isAdmin {
+			r.adminLeft(p)
 		}
 	})
 }
 
-func (r *Room) kick(pID uint8) error {
-	r.mu.RLock()
+// adminLeft hands the admin role over to the longest connected participant if the room has
+// auto promotion enabled, otherwise or if there is nobody to promote, the room is closed
+func (r *Room) adminLeft(admin *Participant) {
+	r.mu.Lock()
+	if r.checkOpen() != nil {
+		r.mu.Unlock()
+		return
+	}
+
+	// The role could have been transferred or the participant removed before the lock was taken
+	if current, exists := r.getParticipantByID(admin.id); !exists || current != admin || admin.role != common.Admin {
+		r.mu.Unlock()
+		return
+	}
+
+	var successor *Participant
+	if r.autoPromote {
+		successor = r.getLongestConnected(admin.id)
+	}
+
+	if successor == nil {
+		r.mu.Unlock()
+		r.Close(false)
+		return
+	}
+
+	prevRole := successor.role
+	successor.role = common.Admin
+
+	// without a token saying so the successor can't act as the admin, the room is closed instead
+	if _, err := r.reissueToken(successor, successor.tokenExp); err != nil {
+		successor.role = prevRole
+		r.mu.Unlock()
+		r.Close(false)
+		return
+	}
+
+	r.removeParticipant(admin)
+	r.mu.Unlock()
+
+	admin.cleanupWSConn("")
+	r.broadcastLeave(admin.id)
+	r.broadcastRoleChange(successor.id, successor.role)
+}
+
+// transferAdmin makes the target participant the admin of the room and demotes the current admin.
+// Both get new tokens over WS, the new token of the former admin is returned.
+func (r *Room) transferAdmin(adminID, targetID uint32) (string, error) {
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return "", err
+	}
+
+	admin, exists := r.getParticipantByID(adminID)
+	if !exists || admin.role != common.Admin {
+		r.mu.Unlock()
+		return "", fmt.Errorf("Participant with ID '%d' not found in room", adminID)
+	}
+
+	target, exists := r.getParticipantByID(targetID)
+	if !exists {
+		r.mu.Unlock()
+		return "", fmt.Errorf("Participant with ID '%d' not found in room", targetID)
+	}
+
+	if target.role == common.Spectator {
+		r.mu.Unlock()
+		return "", errSpectatorRole
+	}
+
+	prevTargetRole := target.role
+	admin.role = common.Member
+	target.role = common.Admin
+
+	// both tokens are created before either is sent, a failure leaves both participants as they were
+	token, err := r.createToken(admin, admin.tokenExp)
+	var targetToken string
+	if err == nil {
+		targetToken, err = r.createToken(target, target.tokenExp)
+	}
+	if err != nil {
+		admin.role = common.Admin
+		target.role = prevTargetRole
+		r.mu.Unlock()
+		return "", err
+	}
+
+	admin.send(encodeWSMessage("token", TokenMsg{Token: token}))
+	target.send(encodeWSMessage("token", TokenMsg{Token: targetToken}))
+	r.mu.Unlock()
+
+	r.broadcastRoleChange(admin.id, admin.role)
+	r.broadcastRoleChange(target.id, target.role)
+
+	return token, nil
+}
+
+// setRole changes the role of a participant, the participant gets a new token over WS
+func (r *Room) setRole(pID uint32, role common.Role) error {
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+
 	p, exists := r.getParticipantByID(pID)
-	r.mu.RUnlock()
 	if !exists {
+		r.mu.Unlock()
 		return fmt.Errorf("Participant with ID '%d' not found in room", pID)
 	}
 
+	// spectators don't count against maxParticipants, giving them a role could overfill the room
+	if p.role == common.Spectator {
+		r.mu.Unlock()
+		return errSpectatorRole
+	}
+
+	prevRole := p.role
+	p.role = role
+
+	if _, err := r.reissueToken(p, p.tokenExp); err != nil {
+		p.role = prevRole
+		r.mu.Unlock()
+		return err
+	}
+	r.mu.Unlock()
+
+	r.broadcastRoleChange(pID, role)
+
+	return nil
+}
+
+var errUsernameTaken = errors.New("This username is taken.")
+
+// rename changes the username of a participant, the participant gets a new token since the username is in the claims.
+// Renaming to the current username changes nothing and returns no token.
+func (r *Room) rename(pID uint32, username string) (string, error) {
 	r.mu.Lock()
-	delete(r.participants, p.sessionID)
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return "", err
+	}
+
+	p, exists := r.getParticipantByID(pID)
+	if !exists {
+		r.mu.Unlock()
+		return "", fmt.Errorf("Participant with ID '%d' not found in room", pID)
+	}
+
+	if username == p.username {
+		r.mu.Unlock()
+		return "", nil
+	}
+
+	if r.isUsernameTaken(username) {
+		r.mu.Unlock()
+		return "", errUsernameTaken
+	}
+
+	prevUsername := p.username
+	p.username = username
+
+	token, err := r.reissueToken(p, p.tokenExp)
+	if err != nil {
+		p.username = prevUsername
+		r.mu.Unlock()
+		return "", err
+	}
+
+	delete(r.usernames, prevUsername)
+	r.usernames[username] = p
+	r.mu.Unlock()
+
+	r.broadcastRename(pID, username)
+
+	return token, nil
+}
+
+// kick removes the participant, the optional reason is shown to them and to the rest of the room
+func (r *Room) kick(pID uint32, reason string) error {
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+
+	p, exists := r.getParticipantByID(pID)
+	if !exists {
+		r.mu.Unlock()
+		return fmt.Errorf("Participant with ID '%d' not found in room", pID)
+	}
+
+	r.removeParticipant(p)
 	r.mu.Unlock()
 
 	go func() {
-		p.cleanupWSConn("kick")
+		p.cleanupWSConnWithNotice("kick", encodeWSMessage("removed", RemovedMsg{Action: "kick", Reason: reason}))
+		r.broadcastModeration(p, "kick", reason)
 		r.broadcastLeave(p.id)
 	}()
 
 	return nil
 }
 
-func (r *Room) ban(pID uint8) error {
-	r.mu.RLock()
+// ban removes the participant and stops them from joining again,
+// a duration of 0 bans them for as long as the room lives
+func (r *Room) ban(pID uint32, duration time.Duration, reason string) error {
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+
 	p, exists := r.getParticipantByID(pID)
-	r.mu.RUnlock()
 	if !exists {
+		r.mu.Unlock()
 		return fmt.Errorf("Participant with ID '%d' not found in room", pID)
 	}
 
-	r.mu.Lock()
-	r.bannedParticipants[p.sessionID] = struct{}{}
-	delete(r.participants, p.sessionID)
+	now := time.Now()
+	b := &Ban{
+		ID:       p.id,
+		Username: p.username,
+		BannedAt: now.Unix(),
+	}
+
+	if duration > 0 {
+		b.ExpiresAt = now.Add(duration).Unix()
+		b.expiry = time.AfterFunc(duration, func() {
+			r.mu.Lock()
+			if r.bannedParticipants[p.sessionID] == b {
+				delete(r.bannedParticipants, p.sessionID)
+			}
+			r.mu.Unlock()
+		})
+	}
+	r.bannedParticipants[p.sessionID] = b
+	r.removeParticipant(p)
 	r.mu.Unlock()
 
 	go func() {
-		p.cleanupWSConn("ban")
+		p.cleanupWSConnWithNotice("ban", encodeWSMessage("removed", RemovedMsg{Action: "ban", Reason: reason}))
+		r.broadcastModeration(p, "ban", reason)
 		r.broadcastLeave(p.id)
 	}()
 
 	return nil
 }
 
+func (r *Room) unban(pID uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkOpen(); err != nil {
+		return err
+	}
+
+	for sessionID, b := range r.bannedParticipants {
+		if b.ID == pID {
+			if b.expiry != nil {
+				b.expiry.Stop()
+			}
+			delete(r.bannedParticipants, sessionID)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Banned participant with ID '%d' not found in room", pID)
+}
+
+// rotateInvite replaces the room secret key, which invalidates every invite token signed with the old one,
+// and creates a new invite link. Other participants that can invite get the new link over WS.
+func (r *Room) rotateInvite(origin string, byID uint32) (string, error) {
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return "", err
+	}
+
+	secretKey := generateRandomString(10)
+
+	inviteLink, err := createInviteLink(origin, r.roomID, secretKey, r.expiresAt)
+	if err != nil {
+		r.mu.Unlock()
+		return "", err
+	}
+
+	r.secretKey = secretKey
+	r.inviteLink = inviteLink
+	// minted invites are signed with the old secret key and can't be used anymore
+	clear(r.invites)
+	r.mu.Unlock()
+
+	r.broadcastInviteChange(inviteLink, byID)
+
+	return inviteLink, nil
+}
+
+// setLocked opens or closes the room for new joins, participants are only notified when the state changes
+func (r *Room) setLocked(locked bool) error {
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+
+	if r.locked == locked {
+		r.mu.Unlock()
+		return nil
+	}
+	r.locked = locked
+	// slots that freed up while the room was locked go to the waitlist
+	r.admitWaiters()
+	r.mu.Unlock()
+
+	r.broadcastLockChange(locked)
+
+	return nil
+}
+
+// make sure caller locks room for reading
+// banRemaining returns how long the ban of the session still lasts, -1 if it lasts as long as the room
+func (r *Room) banRemaining(sessionID string) (time.Duration, bool) {
+	b, banned := r.bannedParticipants[sessionID]
+	if !banned {
+		return 0, false
+	}
+
+	if b.ExpiresAt == 0 {
+		return -1, true
+	}
+
+	// the expiry timer might not have removed the ban yet
+	remaining := time.Until(time.Unix(b.ExpiresAt, 0))
+	if remaining <= 0 {
+		return 0, false
+	}
+
+	return remaining, true
+}
+
+// make sure caller locks room for reading
+func (r *Room) getBansAsSlice() []Ban {
+	bans := make([]Ban, 0, len(r.bannedParticipants))
+
+	for _, b := range r.bannedParticipants {
+		bans = append(bans, *b)
+	}
+
+	return bans
+}
+
+// extend pushes the room expiration forward by config.RoomExtension, capped at config.MaxRoomLifetime.
+// Participant tokens that would expire before the room are reissued and sent over WS,
+// the returned map (key participant ID) holds the new tokens.
+func (r *Room) extend(origin string) (int64, map[uint32]string, error) {
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return 0, nil, err
+	}
+
+	now := time.Now().Unix()
+	maxExpiresAt := r.createdAt + int64(config.MaxRoomLifetime.Seconds())
+
+	if r.onExpire == nil || r.expiresAt <= now {
+		r.mu.Unlock()
+		return 0, nil, errors.New("Chat Room is about to expire and can't be extended.")
+	}
+
+	if r.expiresAt >= maxExpiresAt {
+		r.mu.Unlock()
+		return 0, nil, errors.New("Chat Room has reached its maximum lifetime and can't be extended.")
+	}
+
+	expiresAt := min(r.expiresAt+int64(config.RoomExtension.Seconds()), maxExpiresAt)
+
+	inviteLink, err := createInviteLink(origin, r.roomID, r.secretKey, expiresAt)
+	if err != nil {
+		r.mu.Unlock()
+		return 0, nil, err
+	}
+
+	tokens := make(map[uint32]string, len(r.participants))
+	for _, p := range r.participants {
+		if p.tokenExp >= expiresAt {
+			continue
+		}
+
+		token, err := r.reissueToken(p, expiresAt)
+		if err != nil {
+			r.mu.Unlock()
+			return 0, nil, err
+		}
+		tokens[p.id] = token
+	}
+
+	r.expiresAt = expiresAt
+	r.inviteLink = inviteLink
+	r.extendInvites(expiresAt)
+	r.onExpire.Reset(time.Until(time.Unix(expiresAt, 0)))
+	r.mu.Unlock()
+
+	r.broadcastExpiryChange(expiresAt)
+
+	return expiresAt, tokens, nil
+}
+
+// make sure caller locks room for reading
+// createToken creates a token for the participant as they are now, valid until exp
+func (r *Room) createToken(p *Participant, exp int64) (string, error) {
+	claims := auth.Claims{
+		UserID:   p.id,
+		Username: p.username,
+		Role:     p.role,
+		RoomID:   r.roomID,
+		Exp:      exp,
+	}
+
+	return auth.CreateToken(claims)
+}
+
+// make sure caller locks room for rw
+// reissueToken creates a new token for the participant that is valid until exp and sends it over WS
+func (r *Room) reissueToken(p *Participant, exp int64) (string, error) {
+	token, err := r.createToken(p, exp)
+	if err != nil {
+		return "", err
+	}
+
+	p.tokenExp = exp
+	p.send(encodeWSMessage("token", TokenMsg{Token: token}))
+
+	return token, nil
+}
+
+// Close moves the room to closing, disconnects everyone and marks it closed once all connections are cleaned up.
+// It is safe to call more than once, only the first call does anything.
 func (r *Room) Close(isScheduled bool) {
 	r.mu.Lock()
+	if r.state != roomOpen {
+		r.mu.Unlock()
+		return
+	}
+	r.state = roomClosing
+
 	participants := make([]*Participant, 0, len(r.participants))
 
 	for _, p := range r.participants {
+		if p.muteTimer != nil {
+			p.muteTimer.Stop()
+		}
+		r.clearTyping(p)
 		participants = append(participants, p)
 	}
 
 	r.participants = nil
+	r.participantsByID = nil
+	r.usernames = nil
+	for _, b := range r.bannedParticipants {
+		if b.expiry != nil {
+			b.expiry.Stop()
+		}
+	}
 	r.bannedParticipants = nil
+	r.invites = nil
 
-	r.onExpire.Stop()
-	r.onExpire = nil
+	for _, k := range r.knocks {
+		k.timeout.Stop()
+	}
+	r.knocks = nil
+	r.history = nil
+	r.stopReceipts()
+	r.releaseWaiters()
+	r.passwordHash = nil
+
+	if r.onExpire != nil {
+		r.onExpire.Stop()
+		r.onExpire = nil
+	}
 
 	onClose := r.onClose
 	r.onClose = nil
@@ -165,6 +705,7 @@ func (r *Room) Close(isScheduled bool) {
 	roomID := r.roomID
 	r.mu.Unlock()
 
+	var wg sync.WaitGroup
 	for _, p := range participants {
 		var reason string
 
@@ -178,9 +719,20 @@ func (r *Room) Close(isScheduled bool) {
 			}
 		}
 
-		go p.cleanupWSConn(reason)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.cleanupWSConn(reason)
+		}()
 	}
 
+	go func() {
+		wg.Wait()
+		r.mu.Lock()
+		r.state = roomClosed
+		r.mu.Unlock()
+	}()
+
 	if onClose != nil {
 		go onClose(roomID)
 	}
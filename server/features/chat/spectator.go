@@ -0,0 +1,47 @@
+package chat
+
+import (
+	"errors"
+
+	"kseli/common"
+	"kseli/config"
+)
+
+// Spectators join with a spectator invite, they can follow the chat but can't post
+// and they don't take up one of the maxParticipants slots
+var (
+	errSpectatorsFull = errors.New("Chat Room can't take any more spectators.")
+	errSpectatorRole  = errors.New("Spectators can't be given another role.")
+	errSpectatorPost  = errors.New("Spectators can't send messages.")
+)
+
+// make sure caller locks room for reading
+// memberCount is the number of participants taking up one of the maxParticipants slots
+func (r *Room) memberCount() int {
+	return len(r.participants) - r.spectatorCount
+}
+
+// make sure caller locks room for reading
+func (r *Room) isFull(spectator bool) bool {
+	if spectator {
+		return r.spectatorCount >= int(config.MaxRoomSpectators)
+	}
+	return r.memberCount() >= int(r.maxParticipants)
+}
+
+// checkCanPost returns errSpectatorPost for participants without the post permission, the caller then drops their message
+func (r *Room) checkCanPost(id uint32) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, exists := r.getParticipantByID(id)
+	if !exists {
+		return errMsgNotSent
+	}
+
+	if !p.role.Can(common.PermPost) {
+		return errSpectatorPost
+	}
+
+	return nil
+}
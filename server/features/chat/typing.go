@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"encoding/json"
+	"time"
+
+	"kseli/common"
+	"kseli/config"
+)
+
+// TypingMsg tells the others in the room that a participant started or stopped typing, it is never stored
+type TypingMsg struct {
+	ID     uint32 `json:"id"`
+	Typing bool   `json:"typing"`
+}
+
+func (r *Room) handleTypingCommand(id uint32, role common.Role, data json.RawMessage) (AckMsg, string) {
+	var msg TypingMsg
+
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return AckMsg{}, "Typing state is required in the command."
+	}
+
+	if !role.Can(common.PermPost) {
+		return AckMsg{}, "Spectators can't send messages."
+	}
+
+	// stopping is still allowed, so the indicator of a participant muted mid typing goes away
+	if msg.Typing && r.checkMuted(id) != nil {
+		return AckMsg{}, "Muted participants can't send typing updates."
+	}
+
+	if err := r.setTyping(id, msg.Typing); err != "" {
+		return AckMsg{}, err
+	}
+
+	return AckMsg{}, ""
+}
+
+// setTyping broadcasts typing changes of the participant. Repeated starts only keep the typing state alive,
+// it is cleared after config.TypingTimeout without one. A new start is allowed once every config.TypingCooldown.
+func (r *Room) setTyping(id uint32, typing bool) string {
+	r.mu.Lock()
+	if err := r.checkOpen(); err != nil {
+		r.mu.Unlock()
+		return err.Error()
+	}
+
+	p, exists := r.getParticipantByID(id)
+	if !exists {
+		r.mu.Unlock()
+		return ""
+	}
+
+	if p.typing == typing {
+		if typing {
+			p.typingTimer.Reset(config.TypingTimeout)
+		}
+		r.mu.Unlock()
+		return ""
+	}
+
+	if typing {
+		if time.Since(p.typingAt) < config.TypingCooldown {
+			r.mu.Unlock()
+			return "Typing updates are sent too often."
+		}
+
+		startedAt := time.Now()
+		p.typing = true
+		p.typingAt = startedAt
+		p.typingTimer = time.AfterFunc(config.TypingTimeout, func() {
+			r.mu.Lock()
+			// the participant might have stopped and started typing again since
+			if r.checkOpen() != nil || !p.typing || !p.typingAt.Equal(startedAt) {
+				r.mu.Unlock()
+				return
+			}
+			r.clearTyping(p)
+			r.mu.Unlock()
+
+			r.broadcastTyping(id, false)
+		})
+	} else {
+		r.clearTyping(p)
+	}
+	r.mu.Unlock()
+
+	r.broadcastTyping(id, typing)
+
+	return ""
+}
+
+// make sure caller locks room for rw
+// clearTyping resets the typing state without telling anyone, it returns true if the participant was typing
+func (r *Room) clearTyping(p *Participant) bool {
+	if p.typingTimer != nil {
+		p.typingTimer.Stop()
+		p.typingTimer = nil
+	}
+
+	wasTyping := p.typing
+	p.typing = false
+
+	return wasTyping
+}
+
+// broadcastTyping sends the typing change to everyone except the participant that is typing
+func (r *Room) broadcastTyping(pID uint32, typing bool) {
+	msg := encodeWSMessage("typing", TypingMsg{ID: pID, Typing: typing})
+	r.broadcastToOthers(msg, pID)
+}
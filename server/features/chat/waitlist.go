@@ -0,0 +1,205 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"kseli/auth"
+	"kseli/common"
+	"kseli/config"
+)
+
+var errWaitlistFull = errors.New("Chat Room is full and so is its waitlist.")
+
+// Waiter is a joiner queued for a full room in rooms with the waitlist enabled.
+// The joiner keeps repeating the join request, which waits until they are admitted or config.WaitlistPollTimeout passes.
+type Waiter struct {
+	sessionID string
+	username  string
+	invite    *auth.InviteClaims
+	// ready is closed once the waiter is admitted or can't be admitted anymore
+	ready       chan struct{}
+	participant *Participant
+	err         error
+	// timeout drops the waiter if they stop repeating the join request
+	timeout *time.Timer
+}
+
+// make sure caller locks room for reading
+func (r *Room) getWaiter(sessionID string) (*Waiter, int) {
+	for i, w := range r.waiters {
+		if w.sessionID == sessionID {
+			return w, i
+		}
+	}
+
+	return nil, -1
+}
+
+// make sure caller locks room for reading
+// waitlistPosition returns the 1 based place of the waiter in the queue, 0 if they are not queued anymore
+func (r *Room) waitlistPosition(w *Waiter) int {
+	waiting := 0
+
+	for _, queued := range r.waiters {
+		if queued.participant != nil || queued.err != nil {
+			continue
+		}
+		waiting++
+		if queued == w {
+			return waiting
+		}
+	}
+
+	return 0
+}
+
+// make sure caller locks room for rw
+func (r *Room) enqueueWaiter(sessionID, username string, invite *auth.InviteClaims) (*Waiter, error) {
+	if len(r.waiters) >= int(config.MaxWaitlistSize) {
+		return nil, errWaitlistFull
+	}
+
+	w := &Waiter{
+		sessionID: sessionID,
+		username:  username,
+		invite:    invite,
+		ready:     make(chan struct{}),
+	}
+
+	w.timeout = time.AfterFunc(config.WaitlistTimeout, func() {
+		r.mu.Lock()
+		if current, i := r.getWaiter(sessionID); current == w {
+			r.removeWaiter(i)
+		}
+		r.mu.Unlock()
+	})
+
+	r.waiters = append(r.waiters, w)
+
+	return w, nil
+}
+
+// make sure caller locks room for rw
+func (r *Room) removeWaiter(i int) {
+	r.waiters[i].timeout.Stop()
+	r.waiters = append(r.waiters[:i], r.waiters[i+1:]...)
+}
+
+// make sure caller locks room for rw
+// admitWaiters fills free slots with the waiters in the order they were queued,
+// the admitted participants pick up their tokens on their next join request
+func (r *Room) admitWaiters() {
+	if r.checkOpen() != nil || r.locked {
+		return
+	}
+
+	for _, w := range r.waiters {
+		if r.isFull(false) {
+			return
+		}
+
+		if w.participant != nil || w.err != nil {
+			continue
+		}
+
+		// the room could have changed since the waiter was queued
+		if err := r.checkInviteAccess(w.invite); err != nil {
+			w.err = err
+		} else if r.isUsernameTaken(w.username) {
+			w.err = errUsernameTaken
+		} else {
+			p := &Participant{
+				sessionID: w.sessionID,
+				id:        r.nextParticipantID,
+				username:  w.username,
+				role:      common.Member,
+				inviteID:  w.invite.InviteID,
+				tokenExp:  r.expiresAt,
+				readSeq:   r.msgSeq,
+			}
+			r.nextParticipantID++
+
+			r.join(p)
+			r.consumeInvite(w.invite.InviteID)
+			w.participant = p
+		}
+
+		close(w.ready)
+	}
+}
+
+// make sure caller locks room for rw
+// pickUpWaiter handles a repeated join request of a queued joiner, ok is false if the joiner has to be admitted as a new one
+func (r *Room) pickUpWaiter(sessionID, username string) (admission, bool, error) {
+	w, i := r.getWaiter(sessionID)
+	if w == nil {
+		return admission{}, false, nil
+	}
+
+	switch {
+	case w.username != username:
+		r.removeWaiter(i)
+		return admission{}, false, nil
+
+	case w.err != nil:
+		r.removeWaiter(i)
+		return admission{}, true, w.err
+
+	case w.participant != nil:
+		r.removeWaiter(i)
+		// the participant might have been removed for not connecting in time
+		if current, exists := r.participants[sessionID]; !exists || current != w.participant {
+			return admission{}, false, nil
+		}
+		return admission{participant: w.participant}, true, nil
+	}
+
+	w.timeout.Reset(config.WaitlistTimeout)
+
+	return admission{waiter: w}, true, nil
+}
+
+// waitForSlot blocks until the waiter is admitted, config.WaitlistPollTimeout passes or the request is cancelled.
+// The waiter is handled the same way as a repeated join request once a slot frees up.
+func (r *Room) waitForSlot(ctx context.Context, w *Waiter) (admission, error) {
+	timer := time.NewTimer(config.WaitlistPollTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-w.ready:
+	case <-timer.C:
+		return admission{waiter: w}, nil
+	case <-ctx.Done():
+		return admission{waiter: w}, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkOpen(); err != nil {
+		return admission{}, err
+	}
+
+	adm, ok, err := r.pickUpWaiter(w.sessionID, w.username)
+	if !ok {
+		// the waiter lost the slot, the next join request queues them again
+		return admission{waiter: w}, nil
+	}
+
+	return adm, err
+}
+
+// make sure caller locks room for rw
+// releaseWaiters wakes every waiter when the room closes, their join request then fails with errRoomClosed
+func (r *Room) releaseWaiters() {
+	for _, w := range r.waiters {
+		w.timeout.Stop()
+		if w.participant == nil && w.err == nil {
+			w.err = errRoomClosed
+			close(w.ready)
+		}
+	}
+	r.waiters = nil
+}
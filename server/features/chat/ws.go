@@ -2,6 +2,7 @@ package chat
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net"
 	"time"
@@ -18,35 +19,99 @@ type WSMsg struct {
 }
 
 type ChatMsg struct {
+	ID       uint32 `json:"id"`
 	Username string `json:"username"`
 	Content  string `json:"content"`
+	MsgMeta
+}
+
+// MsgMeta is given to every chat message by the server when it is broadcast,
+// seq grows by one with every message in the room so clients can order and deduplicate them
+type MsgMeta struct {
+	MsgID  string `json:"msgId"`
+	Seq    uint64 `json:"seq"`
+	SentAt int64  `json:"sentAt"` // unix milliseconds
 }
 
 type JoinMsg struct {
-	ID       uint8       `json:"id"`
+	ID       uint32      `json:"id"`
 	Username string      `json:"username"`
 	Role     common.Role `json:"role"`
 }
 
 type LeaveMsg struct {
-	ID uint8 `json:"id"`
+	ID uint32 `json:"id"`
+}
+
+type ExpiryMsg struct {
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+type LockMsg struct {
+	Locked bool `json:"locked"`
+}
+
+type RenameMsg struct {
+	ID       uint32 `json:"id"`
+	Username string `json:"username"`
+}
+
+// RemovedMsg is sent to a kicked or banned participant right before their connection is closed
+type RemovedMsg struct {
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ModerationMsg tells the rest of the room who was kicked or banned and why
+type ModerationMsg struct {
+	ID       uint32 `json:"id"`
+	Username string `json:"username"`
+	Action   string `json:"action"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type TokenMsg struct {
+	Token string `json:"token"`
+}
+
+type RoleMsg struct {
+	ID   uint32      `json:"id"`
+	Role common.Role `json:"role"`
 }
 
-func (r *Room) addWSConn(conn net.Conn, username string) {
+type InviteMsg struct {
+	InviteLink string `json:"inviteLink"`
+}
+
+type ErrorMsg struct {
+	// ID of the command that failed, 0 when the error isn't an answer to a command
+	ID      uint32 `json:"id,omitempty"`
+	Message string `json:"message"`
+}
+
+func (r *Room) addWSConn(conn net.Conn, id uint32) {
 	r.mu.RLock()
-	p, exists := r.getParticipantByUsername(username)
-	if !exists {
+	p, exists := r.getParticipantByID(id)
+	if r.checkOpen() != nil || !exists {
 		r.mu.RUnlock()
 		wsutil.WriteServerMessage(conn, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "user-not-exists"))
 		conn.Close()
 		return
 	}
 
-	id := p.id
+	username := p.username
 	role := p.role
 	r.mu.RUnlock()
 
 	r.mu.Lock()
+	// the room might have been closed or the participant removed since the lookup
+	if _, exists := r.getParticipantByID(id); r.checkOpen() != nil || !exists {
+		r.mu.Unlock()
+		wsutil.WriteServerMessage(conn, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "user-not-exists"))
+		conn.Close()
+		return
+	}
+
 	// WS connection established, we stop the timeout timer
 	if p.wsTimeout != nil {
 		p.wsTimeout.Stop()
@@ -62,24 +127,24 @@ func (r *Room) addWSConn(conn net.Conn, username string) {
 	}
 
 	p.wsConn = conn
-	p.msgQueue = make(chan []byte, 20)
+	p.msgQueue = make(chan queuedMsg, 20)
 	r.mu.Unlock()
 
 	pongChan := make(chan struct{}, 1)
 
 	go r.broadcastJoin(id, username, role)
-	go r.handleRead(conn, username, pongChan)
-	go r.handleWrite(conn, username, p.msgQueue, pongChan)
+	go r.handleRead(conn, id, pongChan)
+	go r.handleWrite(conn, id, p.msgQueue, pongChan)
 }
 
-func (r *Room) handleRead(conn net.Conn, username string, pongChan chan<- struct{}) {
+func (r *Room) handleRead(conn net.Conn, id uint32, pongChan chan<- struct{}) {
 	var cleanup bool
 	const maxMsgSize = 1024
 
 	defer func() {
 		if cleanup {
 			close(pongChan)
-			r.rmParticipantFromRoom(username)
+			r.rmParticipantFromRoom(id)
 		}
 	}()
 
@@ -103,7 +168,16 @@ func (r *Room) handleRead(conn net.Conn, username string, pongChan chan<- struct
 
 		switch hdr.OpCode {
 		case ws.OpText:
-			r.broadcastChatMsg(username, string(buf[:n]))
+			if cmd, ok := parseWSCommand(buf[:n]); ok {
+				r.handleCommand(id, cmd)
+				continue
+			}
+
+			// plain text frames from clients that don't use the command envelope, they get no receipts
+			// and no command reply, a refused message is answered with a notice instead
+			if _, err := r.postChatMsg(id, string(buf[:n]), false); err != nil {
+				r.sendPostRefusal(id, err)
+			}
 
 		case ws.OpClose:
 			_, reason := ws.ParseCloseFrameData(buf[:n])
@@ -126,13 +200,13 @@ func (r *Room) handleRead(conn net.Conn, username string, pongChan chan<- struct
 	}
 }
 
-func (r *Room) handleWrite(conn net.Conn, username string, msgQueue <-chan []byte, pongChan chan struct{}) {
+func (r *Room) handleWrite(conn net.Conn, id uint32, msgQueue <-chan queuedMsg, pongChan chan struct{}) {
 	var cleanup bool
 
 	defer func() {
 		if cleanup {
 			close(pongChan)
-			r.rmParticipantFromRoom(username)
+			r.rmParticipantFromRoom(id)
 		}
 	}()
 
@@ -152,11 +226,15 @@ func (r *Room) handleWrite(conn net.Conn, username string, msgQueue <-chan []byt
 				return
 			}
 
-			if err := wsutil.WriteServerMessage(conn, ws.OpText, msg); err != nil {
+			if err := wsutil.WriteServerMessage(conn, ws.OpText, msg.data); err != nil {
 				cleanup = true
 				return
 			}
 
+			if msg.seq != 0 {
+				r.markDelivered(msg.seq, id)
+			}
+
 		case <-pongChan:
 			lastPong = time.Now()
 
@@ -173,10 +251,19 @@ func (r *Room) handleWrite(conn net.Conn, username string, msgQueue <-chan []byt
 }
 
 func (p *Participant) cleanupWSConn(reason string) {
+	p.cleanupWSConnWithNotice(reason, nil)
+}
+
+// cleanupWSConnWithNotice writes the notice straight to the connection before it is closed,
+// going through msgQueue would race with closing the queue
+func (p *Participant) cleanupWSConnWithNotice(reason string, notice []byte) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	if p.wsConn != nil {
+		if notice != nil {
+			wsutil.WriteServerMessage(p.wsConn, ws.OpText, notice)
+		}
 		wsutil.WriteServerMessage(p.wsConn, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, reason))
 		p.wsConn.Close()
 		p.wsConn = nil
@@ -188,24 +275,29 @@ func (p *Participant) cleanupWSConn(reason string) {
 	}
 }
 
-func (r *Room) rmParticipantFromRoom(username string) {
-	r.mu.RLock()
-	p, exists := r.getParticipantByUsername(username)
+func (r *Room) rmParticipantFromRoom(id uint32) {
+	r.mu.Lock()
+	p, exists := r.getParticipantByID(id)
 	if !exists {
-		r.mu.RUnlock()
+		r.mu.Unlock()
 		return
 	}
 	role := p.role
-	r.mu.RUnlock()
+	wasTyping := r.clearTyping(p)
+	r.mu.Unlock()
+
+	if wasTyping {
+		r.broadcastTyping(id, false)
+	}
 
 	if role == common.Admin {
-		// Admin disconnects -> room shuts down
+		// Admin disconnects -> admin role is handed over or the room shuts down
 		// The participant will be an Admin only in case of ping-pong failure
 		// Otherwise r.Close will be called from a handler
-		r.Close(false)
+		r.adminLeft(p)
 	} else {
 		r.mu.Lock()
-		delete(r.participants, p.sessionID)
+		r.removeParticipant(p)
 		r.mu.Unlock()
 
 		p.cleanupWSConn("")
@@ -213,15 +305,118 @@ func (r *Room) rmParticipantFromRoom(username string) {
 	}
 }
 
-func (r *Room) broadcastChatMsg(username, content string) {
-	msg := encodeWSMessage("msg", ChatMsg{
-		Username: username,
+var errMsgNotSent = errors.New("Message was not sent.")
+
+// postChatMsg broadcasts the chat message unless the sender can't post right now, the returned error tells why.
+// With receipts the sender is told about deliveries and reads of the message.
+func (r *Room) postChatMsg(id uint32, content string, receipts bool) (MsgMeta, error) {
+	if err := r.checkCanPost(id); err != nil {
+		return MsgMeta{}, err
+	}
+
+	if err := r.checkMuted(id); err != nil {
+		return MsgMeta{}, err
+	}
+
+	if wait, limited := r.checkRateLimit(id); limited {
+		return MsgMeta{}, &rateLimitError{wait: wait}
+	}
+
+	meta, ok := r.broadcastChatMsg(id, content, receipts)
+	if !ok {
+		return MsgMeta{}, errMsgNotSent
+	}
+
+	return meta, nil
+}
+
+// sendPostRefusal tells a plain text sender why their chat message was dropped
+func (r *Room) sendPostRefusal(id uint32, err error) {
+	var muteErr *muteError
+	var limitErr *rateLimitError
+	var notice []byte
+
+	switch {
+	case errors.As(err, &muteErr):
+		notice = encodeWSMessage("muted", MuteMsg{ID: id, MutedUntil: muteErr.until})
+	case errors.As(err, &limitErr):
+		notice = encodeWSMessage("rate-limited", RateLimitMsg{RetryAfterMs: limitErr.wait.Milliseconds() + 1})
+	case errors.Is(err, errSpectatorPost):
+		notice = encodeWSMessage("error", ErrorMsg{Message: err.Error()})
+	default:
+		return
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if p, exists := r.getParticipantByID(id); exists {
+		p.send(notice)
+	}
+}
+
+// broadcastChatMsg looks up the sender when the message is sent since the username can change.
+// The sequence number is taken and the message queued for everyone under the same lock,
+// so every participant gets the messages in sequence order.
+func (r *Room) broadcastChatMsg(id uint32, content string, receipts bool) (MsgMeta, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.checkOpen() != nil {
+		return MsgMeta{}, false
+	}
+
+	p, exists := r.getParticipantByID(id)
+	if !exists {
+		return MsgMeta{}, false
+	}
+
+	r.msgSeq++
+	meta := MsgMeta{
+		MsgID:  generateRandomString(8),
+		Seq:    r.msgSeq,
+		SentAt: time.Now().UnixMilli(),
+	}
+
+	chatMsg := ChatMsg{
+		ID:       id,
+		Username: p.username,
 		Content:  content,
-	})
-	r.broadcastMessage(msg)
+		MsgMeta:  meta,
+	}
+	if r.history != nil {
+		r.history.add(chatMsg)
+	}
+
+	msg := encodeWSMessage("msg", chatMsg)
+
+	if !receipts {
+		for _, p := range r.participants {
+			p.send(msg)
+		}
+		return meta, true
+	}
+
+	// the tracker stays locked until every recipient is known, deliveries reported before that wait for it
+	r.receipts.mu.Lock()
+	defer r.receipts.mu.Unlock()
+
+	rc := r.trackReceipts(meta, id)
+	for _, p := range r.participants {
+		if p.id == id {
+			// the sender gets their own message back, that isn't a delivery
+			p.send(msg)
+			continue
+		}
+		if p.queue(queuedMsg{data: msg, seq: meta.Seq}) {
+			rc.queued[p.id] = struct{}{}
+		}
+	}
+
+	return meta, true
 }
 
-func (r *Room) broadcastJoin(id uint8, uname string, role common.Role) {
+func (r *Room) broadcastJoin(id uint32, uname string, role common.Role) {
 	msg := encodeWSMessage("join", JoinMsg{
 		ID:       id,
 		Username: uname,
@@ -230,23 +425,110 @@ func (r *Room) broadcastJoin(id uint8, uname string, role common.Role) {
 	r.broadcastMessage(msg)
 }
 
-func (r *Room) broadcastLeave(pID uint8) {
+func (r *Room) broadcastLeave(pID uint32) {
 	msg := encodeWSMessage("leave", LeaveMsg{ID: pID})
 	r.broadcastMessage(msg)
 }
 
-func (r *Room) broadcastMessage(msg []byte) {
+func (r *Room) broadcastExpiryChange(expiresAt int64) {
+	msg := encodeWSMessage("expiry-changed", ExpiryMsg{ExpiresAt: expiresAt})
+	r.broadcastMessage(msg)
+}
+
+func (r *Room) broadcastLockChange(locked bool) {
+	msg := encodeWSMessage("lock-changed", LockMsg{Locked: locked})
+	r.broadcastMessage(msg)
+}
+
+func (r *Room) broadcastModeration(p *Participant, action, reason string) {
+	msg := encodeWSMessage("moderation", ModerationMsg{
+		ID:       p.id,
+		Username: p.username,
+		Action:   action,
+		Reason:   reason,
+	})
+	r.broadcastMessage(msg)
+}
+
+func (r *Room) broadcastRename(pID uint32, username string) {
+	msg := encodeWSMessage("renamed", RenameMsg{ID: pID, Username: username})
+	r.broadcastMessage(msg)
+}
+
+func (r *Room) broadcastRoleChange(pID uint32, role common.Role) {
+	msg := encodeWSMessage("role-changed", RoleMsg{ID: pID, Role: role})
+	r.broadcastMessage(msg)
+}
+
+// broadcastInviteChange sends the new invite link to everyone that can invite, except the participant that changed it
+func (r *Room) broadcastInviteChange(inviteLink string, exceptID uint32) {
+	msg := encodeWSMessage("invite-changed", InviteMsg{InviteLink: inviteLink})
+	r.broadcastToPermitted(msg, common.PermInvite, exceptID)
+}
+
+// broadcastToPermitted sends the message only to participants whose role has the permission,
+// exceptID can be used to skip one participant, 0 skips no one
+func (r *Room) broadcastToPermitted(msg []byte, perm common.Permission, exceptID uint32) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	if r.checkOpen() != nil {
+		return
+	}
+
 	for _, p := range r.participants {
-		select {
-		case p.msgQueue <- msg:
-		default:
+		if p.id != exceptID && p.role.Can(perm) {
+			p.send(msg)
 		}
 	}
 }
 
+// broadcastToOthers sends the message to everyone except the participant with exceptID
+func (r *Room) broadcastToOthers(msg []byte, exceptID uint32) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.checkOpen() != nil {
+		return
+	}
+
+	for _, p := range r.participants {
+		if p.id != exceptID {
+			p.send(msg)
+		}
+	}
+}
+
+func (r *Room) broadcastMessage(msg []byte) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.checkOpen() != nil {
+		return
+	}
+
+	for _, p := range r.participants {
+		p.send(msg)
+	}
+}
+
+// make sure caller locks room for reading
+// send queues a message for a single participant, it is dropped if the participant has no WS connection
+func (p *Participant) send(msg []byte) {
+	p.queue(queuedMsg{data: msg})
+}
+
+// make sure caller locks room for reading
+// queue returns false if the message was dropped
+func (p *Participant) queue(msg queuedMsg) bool {
+	select {
+	case p.msgQueue <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
 func encodeWSMessage(msgType string, data interface{}) []byte {
 	msg := WSMsg{
 		MsgType: msgType,
@@ -52,6 +52,13 @@ func New() *http.ServeMux {
 		middleware.ValidateOrigin(),
 	))
 
+	// POST request to extend the lifetime of the chat room
+	mux.Handle("POST /api/rooms/{roomID}/extend", middleware.WithMiddleware(
+		chat.ExtendRoomHandler(s),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
 	// POST request to kick a participant from a chat room
 	mux.Handle("POST /api/rooms/{roomID}/kick", middleware.WithMiddleware(
 		chat.KickParticipantHandler(s),
@@ -66,6 +73,118 @@ func New() *http.ServeMux {
 		middleware.ValidateOrigin(),
 	))
 
+	// POST request to change the username of yourself or, as an admin, of another participant
+	mux.Handle("POST /api/rooms/{roomID}/rename", middleware.WithMiddleware(
+		chat.RenameParticipantHandler(s),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// POST request to stop relaying the messages of a participant, optionally for a number of minutes
+	mux.Handle("POST /api/rooms/{roomID}/mute", middleware.WithMiddleware(
+		chat.MuteParticipantHandler(s),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// POST request to let a muted participant chat again
+	mux.Handle("POST /api/rooms/{roomID}/unmute", middleware.WithMiddleware(
+		chat.UnmuteParticipantHandler(s),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// POST request to replace the invite link of a chat room, invalidating the old one
+	mux.Handle("POST /api/rooms/{roomID}/invite", middleware.WithMiddleware(
+		chat.RotateInviteHandler(s),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// POST request to mint an additional, optionally limited, invite link
+	mux.Handle("POST /api/rooms/{roomID}/invites", middleware.WithMiddleware(
+		chat.CreateInviteHandler(s),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// GET request to list the additional invite links and their usage
+	mux.Handle("GET /api/rooms/{roomID}/invites", middleware.WithMiddleware(
+		chat.GetInvitesHandler(s),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// POST request to stop new participants from joining the chat room
+	mux.Handle("POST /api/rooms/{roomID}/lock", middleware.WithMiddleware(
+		chat.LockRoomHandler(s, true),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// DELETE request to let new participants join the chat room again
+	mux.Handle("DELETE /api/rooms/{roomID}/lock", middleware.WithMiddleware(
+		chat.LockRoomHandler(s, false),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// PUT request to set the minimum interval between chat messages of a participant, 0 turns slow mode off
+	mux.Handle("PUT /api/rooms/{roomID}/slow-mode", middleware.WithMiddleware(
+		chat.SetSlowModeHandler(s),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// PUT request to set or remove (empty password) the chat room password
+	mux.Handle("PUT /api/rooms/{roomID}/password", middleware.WithMiddleware(
+		chat.SetRoomPasswordHandler(s),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// POST request to let a participant waiting in a knock mode room in
+	mux.Handle("POST /api/rooms/{roomID}/knocks/{knockID}/approve", middleware.WithMiddleware(
+		chat.AnswerKnockHandler(s, true),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// POST request to refuse a participant waiting in a knock mode room
+	mux.Handle("POST /api/rooms/{roomID}/knocks/{knockID}/deny", middleware.WithMiddleware(
+		chat.AnswerKnockHandler(s, false),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// GET request to list the participants banned from a chat room
+	mux.Handle("GET /api/rooms/{roomID}/bans", middleware.WithMiddleware(
+		chat.GetBansHandler(s),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// DELETE request to lift a ban
+	mux.Handle("DELETE /api/rooms/{roomID}/bans/{id}", middleware.WithMiddleware(
+		chat.UnbanParticipantHandler(s),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// POST request to promote or demote a participant
+	mux.Handle("POST /api/rooms/{roomID}/role", middleware.WithMiddleware(
+		chat.SetParticipantRoleHandler(s),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
+	// POST request to hand over the admin role to another participant
+	mux.Handle("POST /api/rooms/{roomID}/transfer", middleware.WithMiddleware(
+		chat.TransferAdminHandler(s),
+		middleware.ValidateParticipantToken(),
+		middleware.ValidateOrigin(),
+	))
+
 	mux.Handle("/ws/room", chat.RoomWSHandler(s))
 
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -1,9 +1,12 @@
 package chat_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -12,6 +15,8 @@ import (
 
 	"kseli/common"
 	"kseli/features/chat"
+
+	"github.com/gobwas/ws"
 )
 
 func sendRequest(handler http.Handler, method, url string, body io.Reader, headers map[string]string) (status int, respBody []byte) {
@@ -24,7 +29,7 @@ func sendRequest(handler http.Handler, method, url string, body io.Reader, heade
 	return w.Result().StatusCode, w.Body.Bytes()
 }
 
-func createRoom(t *testing.T, mustCreate bool, expectedBadStatus int, handler http.Handler, maxParticipants uint8, username, origin, apiKey, sessionID string) (chat.CreateRoomResponse, common.ErrorResponse) {
+func createRoom(t *testing.T, mustCreate bool, expectedBadStatus int, handler http.Handler, maxParticipants uint16, username, origin, apiKey, sessionID string) (chat.CreateRoomResponse, common.ErrorResponse) {
 	headers := map[string]string{
 		"Origin":                   origin,
 		"X-Api-Key":                apiKey,
@@ -59,6 +64,10 @@ func createRoom(t *testing.T, mustCreate bool, expectedBadStatus int, handler ht
 }
 
 func joinRoom(t *testing.T, mustJoin bool, expectedBadStatus int, handler http.Handler, username, origin, token, sessionID string) (chat.JoinRoomResponse, common.ErrorResponse) {
+	return joinRoomWithPassword(t, mustJoin, expectedBadStatus, handler, username, "", origin, token, sessionID)
+}
+
+func joinRoomWithPassword(t *testing.T, mustJoin bool, expectedBadStatus int, handler http.Handler, username, password, origin, token, sessionID string) (chat.JoinRoomResponse, common.ErrorResponse) {
 	headers := map[string]string{
 		"Origin":                   origin,
 		"Authorization":            token,
@@ -66,6 +75,7 @@ func joinRoom(t *testing.T, mustJoin bool, expectedBadStatus int, handler http.H
 	}
 	body, _ := json.Marshal(chat.JoinRoomRequest{
 		Username: username,
+		Password: password,
 	})
 
 	status, respBody := sendRequest(handler, http.MethodPost, "/api/rooms/join", bytes.NewReader(body), headers)
@@ -154,7 +164,7 @@ func deleteRoom(t *testing.T, mustDel bool, expectedBadStatus int, handler http.
 	}
 }
 
-func kickOrBanUser(t *testing.T, mustSucceed bool, expectedBadStatus int, handler http.Handler, userID uint8, action, roomID, origin, token string) common.ErrorResponse {
+func kickOrBanUser(t *testing.T, mustSucceed bool, expectedBadStatus int, handler http.Handler, userID uint32, action, roomID, origin, token string) common.ErrorResponse {
 	headers := map[string]string{
 		"Origin":        origin,
 		"Authorization": token,
@@ -181,3 +191,403 @@ func kickOrBanUser(t *testing.T, mustSucceed bool, expectedBadStatus int, handle
 		return errResp
 	}
 }
+
+// bufferedConn reads through the buffer returned by the WS handshake, which holds
+// any frames the server managed to send right after the upgrade
+type bufferedConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) {
+	return c.br.Read(p)
+}
+
+func dialWS(t *testing.T, wsURL string) net.Conn {
+	t.Helper()
+
+	dialer := ws.Dialer{
+		Header: ws.HandshakeHeaderHTTP{
+			"Origin": []string{"http://kseli.app"},
+		},
+	}
+
+	conn, br, _, err := dialer.Dial(context.Background(), wsURL)
+	if err != nil {
+		t.Fatalf("ws handshake failed: %v", err)
+	}
+
+	if br != nil {
+		return bufferedConn{Conn: conn, br: br}
+	}
+
+	return conn
+}
+
+func extendRoom(t *testing.T, mustExtend bool, expectedBadStatus int, handler http.Handler, roomID, origin, token string) (chat.ExtendRoomResponse, common.ErrorResponse) {
+	headers := map[string]string{
+		"Origin":        origin,
+		"Authorization": token,
+	}
+
+	status, respBody := sendRequest(handler, http.MethodPost, "/api/rooms/"+url.PathEscape(roomID)+"/extend", nil, headers)
+
+	if mustExtend {
+		if status != http.StatusOK {
+			t.Fatalf("expected 200, got %d, body: %s", status, string(respBody))
+		}
+		var resp chat.ExtendRoomResponse
+		if err := json.Unmarshal(respBody, &resp); err != nil {
+			t.Fatalf("failed to unmarshal success resp: %v", err)
+		}
+		return resp, common.ErrorResponse{}
+	} else {
+		if status != expectedBadStatus {
+			t.Fatalf("expected %d, got %d, body: %s", expectedBadStatus, status, string(respBody))
+		}
+		var errResp common.ErrorResponse
+		if err := json.Unmarshal(respBody, &errResp); err != nil {
+			t.Fatalf("failed to unmarshal error resp: %v", err)
+		}
+		return chat.ExtendRoomResponse{}, errResp
+	}
+}
+
+func transferAdmin(t *testing.T, mustTransfer bool, expectedBadStatus int, handler http.Handler, userID uint32, roomID, origin, token string) (chat.TransferAdminResponse, common.ErrorResponse) {
+	headers := map[string]string{
+		"Origin":        origin,
+		"Authorization": token,
+	}
+	body, _ := json.Marshal(chat.UserRequest{
+		TargetUserID: userID,
+	})
+
+	status, respBody := sendRequest(handler, http.MethodPost, "/api/rooms/"+url.PathEscape(roomID)+"/transfer", bytes.NewReader(body), headers)
+
+	if mustTransfer {
+		if status != http.StatusOK {
+			t.Fatalf("expected 200, got %d, body: %s", status, string(respBody))
+		}
+		var resp chat.TransferAdminResponse
+		if err := json.Unmarshal(respBody, &resp); err != nil {
+			t.Fatalf("failed to unmarshal success resp: %v", err)
+		}
+		return resp, common.ErrorResponse{}
+	} else {
+		if status != expectedBadStatus {
+			t.Fatalf("expected %d, got %d, body: %s", expectedBadStatus, status, string(respBody))
+		}
+		var errResp common.ErrorResponse
+		if err := json.Unmarshal(respBody, &errResp); err != nil {
+			t.Fatalf("failed to unmarshal error resp: %v", err)
+		}
+		return chat.TransferAdminResponse{}, errResp
+	}
+}
+
+func setRole(t *testing.T, mustSet bool, expectedBadStatus int, handler http.Handler, userID uint32, role common.Role, roomID, origin, token string) common.ErrorResponse {
+	headers := map[string]string{
+		"Origin":        origin,
+		"Authorization": token,
+	}
+	body, _ := json.Marshal(chat.RoleRequest{
+		TargetUserID: userID,
+		Role:         role,
+	})
+
+	status, respBody := sendRequest(handler, http.MethodPost, "/api/rooms/"+url.PathEscape(roomID)+"/role", bytes.NewReader(body), headers)
+
+	if mustSet {
+		if status != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d, body: %s", status, string(respBody))
+		}
+		return common.ErrorResponse{}
+	} else {
+		if status != expectedBadStatus {
+			t.Fatalf("expected %d, got %d, body: %s", expectedBadStatus, status, string(respBody))
+		}
+		var errResp common.ErrorResponse
+		if err := json.Unmarshal(respBody, &errResp); err != nil {
+			t.Fatalf("failed to unmarshal error resp: %v", err)
+		}
+		return errResp
+	}
+}
+
+func getBans(t *testing.T, mustGet bool, expectedBadStatus int, handler http.Handler, roomID, origin, token string) (chat.GetBansResponse, common.ErrorResponse) {
+	headers := map[string]string{
+		"X-Origin":      origin,
+		"Authorization": token,
+	}
+
+	status, respBody := sendRequest(handler, http.MethodGet, "/api/rooms/"+url.PathEscape(roomID)+"/bans", nil, headers)
+
+	if mustGet {
+		if status != http.StatusOK {
+			t.Fatalf("expected 200, got %d, body: %s", status, string(respBody))
+		}
+		var resp chat.GetBansResponse
+		if err := json.Unmarshal(respBody, &resp); err != nil {
+			t.Fatalf("failed to unmarshal success resp: %v", err)
+		}
+		return resp, common.ErrorResponse{}
+	} else {
+		if status != expectedBadStatus {
+			t.Fatalf("expected %d, got %d, body: %s", expectedBadStatus, status, string(respBody))
+		}
+		var errResp common.ErrorResponse
+		if err := json.Unmarshal(respBody, &errResp); err != nil {
+			t.Fatalf("failed to unmarshal error resp: %v", err)
+		}
+		return chat.GetBansResponse{}, errResp
+	}
+}
+
+func unbanUser(t *testing.T, mustUnban bool, expectedBadStatus int, handler http.Handler, userID, roomID, origin, token string) common.ErrorResponse {
+	headers := map[string]string{
+		"Origin":        origin,
+		"Authorization": token,
+	}
+
+	status, respBody := sendRequest(handler, http.MethodDelete, "/api/rooms/"+url.PathEscape(roomID)+"/bans/"+url.PathEscape(userID), nil, headers)
+
+	if mustUnban {
+		if status != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d, body: %s", status, string(respBody))
+		}
+		return common.ErrorResponse{}
+	} else {
+		if status != expectedBadStatus {
+			t.Fatalf("expected %d, got %d, body: %s", expectedBadStatus, status, string(respBody))
+		}
+		var errResp common.ErrorResponse
+		if err := json.Unmarshal(respBody, &errResp); err != nil {
+			t.Fatalf("failed to unmarshal error resp: %v", err)
+		}
+		return errResp
+	}
+}
+
+func rotateInvite(t *testing.T, mustRotate bool, expectedBadStatus int, handler http.Handler, roomID, origin, token string) (string, common.ErrorResponse) {
+	headers := map[string]string{
+		"Origin":        origin,
+		"Authorization": token,
+	}
+
+	status, respBody := sendRequest(handler, http.MethodPost, "/api/rooms/"+url.PathEscape(roomID)+"/invite", nil, headers)
+
+	if mustRotate {
+		if status != http.StatusOK {
+			t.Fatalf("expected 200, got %d, body: %s", status, string(respBody))
+		}
+		var resp chat.RotateInviteResponse
+		if err := json.Unmarshal(respBody, &resp); err != nil {
+			t.Fatalf("failed to unmarshal success resp: %v", err)
+		}
+		parts := strings.Split(resp.InviteLink, "#invite=")
+		if len(parts) != 2 {
+			t.Fatalf("bad invite link %q", resp.InviteLink)
+		}
+		return parts[1], common.ErrorResponse{}
+	} else {
+		if status != expectedBadStatus {
+			t.Fatalf("expected %d, got %d, body: %s", expectedBadStatus, status, string(respBody))
+		}
+		var errResp common.ErrorResponse
+		if err := json.Unmarshal(respBody, &errResp); err != nil {
+			t.Fatalf("failed to unmarshal error resp: %v", err)
+		}
+		return "", errResp
+	}
+}
+
+func createInvite(t *testing.T, mustCreate bool, expectedBadStatus int, handler http.Handler, req chat.CreateInviteRequest, roomID, origin, token string) (chat.CreateInviteResponse, string, common.ErrorResponse) {
+	headers := map[string]string{
+		"Origin":        origin,
+		"Authorization": token,
+	}
+	body, _ := json.Marshal(req)
+
+	status, respBody := sendRequest(handler, http.MethodPost, "/api/rooms/"+url.PathEscape(roomID)+"/invites", bytes.NewReader(body), headers)
+
+	if mustCreate {
+		if status != http.StatusCreated {
+			t.Fatalf("expected 201, got %d, body: %s", status, string(respBody))
+		}
+		var resp chat.CreateInviteResponse
+		if err := json.Unmarshal(respBody, &resp); err != nil {
+			t.Fatalf("failed to unmarshal success resp: %v", err)
+		}
+		parts := strings.Split(resp.InviteLink, "#invite=")
+		if len(parts) != 2 {
+			t.Fatalf("bad invite link %q", resp.InviteLink)
+		}
+		return resp, parts[1], common.ErrorResponse{}
+	} else {
+		if status != expectedBadStatus {
+			t.Fatalf("expected %d, got %d, body: %s", expectedBadStatus, status, string(respBody))
+		}
+		var errResp common.ErrorResponse
+		if err := json.Unmarshal(respBody, &errResp); err != nil {
+			t.Fatalf("failed to unmarshal error resp: %v", err)
+		}
+		return chat.CreateInviteResponse{}, "", errResp
+	}
+}
+
+func getInvites(t *testing.T, handler http.Handler, roomID, origin, token string) chat.GetInvitesResponse {
+	headers := map[string]string{
+		"X-Origin":      origin,
+		"Authorization": token,
+	}
+
+	status, respBody := sendRequest(handler, http.MethodGet, "/api/rooms/"+url.PathEscape(roomID)+"/invites", nil, headers)
+
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d, body: %s", status, string(respBody))
+	}
+	var resp chat.GetInvitesResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		t.Fatalf("failed to unmarshal success resp: %v", err)
+	}
+	return resp
+}
+
+func answerKnock(t *testing.T, mustAnswer bool, expectedBadStatus int, handler http.Handler, knockID, answer, roomID, origin, token string) common.ErrorResponse {
+	headers := map[string]string{
+		"Origin":        origin,
+		"Authorization": token,
+	}
+
+	status, respBody := sendRequest(handler, http.MethodPost, "/api/rooms/"+url.PathEscape(roomID)+"/knocks/"+url.PathEscape(knockID)+"/"+answer, nil, headers)
+
+	if mustAnswer {
+		if status != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d, body: %s", status, string(respBody))
+		}
+		return common.ErrorResponse{}
+	} else {
+		if status != expectedBadStatus {
+			t.Fatalf("expected %d, got %d, body: %s", expectedBadStatus, status, string(respBody))
+		}
+		var errResp common.ErrorResponse
+		if err := json.Unmarshal(respBody, &errResp); err != nil {
+			t.Fatalf("failed to unmarshal error resp: %v", err)
+		}
+		return errResp
+	}
+}
+
+func setRoomPassword(t *testing.T, mustSet bool, expectedBadStatus int, handler http.Handler, password, roomID, origin, token string) common.ErrorResponse {
+	headers := map[string]string{
+		"Origin":        origin,
+		"Authorization": token,
+	}
+	body, _ := json.Marshal(chat.PasswordRequest{
+		Password: password,
+	})
+
+	status, respBody := sendRequest(handler, http.MethodPut, "/api/rooms/"+url.PathEscape(roomID)+"/password", bytes.NewReader(body), headers)
+
+	if mustSet {
+		if status != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d, body: %s", status, string(respBody))
+		}
+		return common.ErrorResponse{}
+	} else {
+		if status != expectedBadStatus {
+			t.Fatalf("expected %d, got %d, body: %s", expectedBadStatus, status, string(respBody))
+		}
+		var errResp common.ErrorResponse
+		if err := json.Unmarshal(respBody, &errResp); err != nil {
+			t.Fatalf("failed to unmarshal error resp: %v", err)
+		}
+		return errResp
+	}
+}
+
+func lockRoom(t *testing.T, mustLock bool, expectedBadStatus int, handler http.Handler, method, roomID, origin, token string) common.ErrorResponse {
+	headers := map[string]string{
+		"Origin":        origin,
+		"Authorization": token,
+	}
+
+	status, respBody := sendRequest(handler, method, "/api/rooms/"+url.PathEscape(roomID)+"/lock", nil, headers)
+
+	if mustLock {
+		if status != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d, body: %s", status, string(respBody))
+		}
+		return common.ErrorResponse{}
+	} else {
+		if status != expectedBadStatus {
+			t.Fatalf("expected %d, got %d, body: %s", expectedBadStatus, status, string(respBody))
+		}
+		var errResp common.ErrorResponse
+		if err := json.Unmarshal(respBody, &errResp); err != nil {
+			t.Fatalf("failed to unmarshal error resp: %v", err)
+		}
+		return errResp
+	}
+}
+
+func setSlowMode(t *testing.T, mustSet bool, expectedBadStatus int, handler http.Handler, seconds uint16, roomID, origin, token string) common.ErrorResponse {
+	headers := map[string]string{
+		"Origin":        origin,
+		"Authorization": token,
+	}
+	body, _ := json.Marshal(chat.SlowModeRequest{
+		Seconds: seconds,
+	})
+
+	status, respBody := sendRequest(handler, http.MethodPut, "/api/rooms/"+url.PathEscape(roomID)+"/slow-mode", bytes.NewReader(body), headers)
+
+	if mustSet {
+		if status != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d, body: %s", status, string(respBody))
+		}
+		return common.ErrorResponse{}
+	} else {
+		if status != expectedBadStatus {
+			t.Fatalf("expected %d, got %d, body: %s", expectedBadStatus, status, string(respBody))
+		}
+		var errResp common.ErrorResponse
+		if err := json.Unmarshal(respBody, &errResp); err != nil {
+			t.Fatalf("failed to unmarshal error resp: %v", err)
+		}
+		return errResp
+	}
+}
+
+func renameUser(t *testing.T, mustRename bool, expectedBadStatus int, handler http.Handler, userID uint32, username, roomID, origin, token string) (chat.RenameResponse, common.ErrorResponse) {
+	headers := map[string]string{
+		"Origin":        origin,
+		"Authorization": token,
+	}
+	body, _ := json.Marshal(chat.RenameRequest{
+		TargetUserID: userID,
+		Username:     username,
+	})
+
+	status, respBody := sendRequest(handler, http.MethodPost, "/api/rooms/"+url.PathEscape(roomID)+"/rename", bytes.NewReader(body), headers)
+
+	if mustRename {
+		if status != http.StatusOK {
+			t.Fatalf("expected 200, got %d, body: %s", status, string(respBody))
+		}
+		var resp chat.RenameResponse
+		if err := json.Unmarshal(respBody, &resp); err != nil {
+			t.Fatalf("failed to unmarshal success resp: %v", err)
+		}
+		return resp, common.ErrorResponse{}
+	} else {
+		if status != expectedBadStatus {
+			t.Fatalf("expected %d, got %d, body: %s", expectedBadStatus, status, string(respBody))
+		}
+		var errResp common.ErrorResponse
+		if err := json.Unmarshal(respBody, &errResp); err != nil {
+			t.Fatalf("failed to unmarshal error resp: %v", err)
+		}
+		return chat.RenameResponse{}, errResp
+	}
+}
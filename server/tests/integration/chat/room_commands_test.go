@@ -0,0 +1,231 @@
+package chat_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"kseli/config"
+	"kseli/features/chat"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+// newCommandConns connects the admin and a user of a new room over WS
+func newCommandConns(t *testing.T) (adminConn, userConn net.Conn) {
+	env := newJoinEnv(t)
+	joinResp, _ := joinRoom(t, true, 0, env.mux, "user", "http://kseli.app", env.invitetoken, "user")
+
+	server := httptest.NewServer(env.mux)
+	t.Cleanup(server.Close)
+	serverAddr := server.Listener.Addr().String()
+
+	adminConn = dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, adminConn)
+
+	userConn = dialWS(t, "ws://"+serverAddr+"/ws/room?token="+joinResp.Token)
+	mustReadWSJoin(t, adminConn)
+	mustReadWSJoin(t, userConn)
+
+	return adminConn, userConn
+}
+
+func mustSendWSText(t *testing.T, conn net.Conn, frame string) {
+	t.Helper()
+
+	if err := wsutil.WriteClientText(conn, []byte(frame)); err != nil {
+		t.Fatalf("failed to send frame: %v", err)
+	}
+}
+
+// mustReadWSTypes reads one message of each type in any order, the data of each is decoded into the map value
+func mustReadWSTypes(t *testing.T, conn net.Conn, want map[string]any) {
+	t.Helper()
+
+	for range len(want) {
+		raw, _, err := wsutil.ReadServerData(conn)
+		if err != nil {
+			t.Fatalf("ReadServerData failed: %v", err)
+		}
+
+		var wsMsg struct {
+			MsgType string          `json:"type"`
+			Data    json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(raw, &wsMsg); err != nil {
+			t.Fatalf("Failed to unmarshal WSMsg: %v", err)
+		}
+
+		data, ok := want[wsMsg.MsgType]
+		if !ok {
+			t.Fatalf("Unexpected WSMsg type %q", wsMsg.MsgType)
+		}
+		if err := json.Unmarshal(wsMsg.Data, data); err != nil {
+			t.Fatalf("Failed to unmarshal %s data: %v", wsMsg.MsgType, err)
+		}
+		delete(want, wsMsg.MsgType)
+	}
+}
+
+func Test_Commands_MsgWithAck(t *testing.T) {
+	conn1, conn2 := newCommandConns(t)
+
+	// 1) The message is relayed to everyone and the sender gets the ack
+	mustSendWSText(t, conn2, `{"type":"msg","id":7,"data":{"content":"hello"}}`)
+
+	assertChatMsg(t, mustReadWSChat(t, conn1), "user", "hello")
+	assertChatMsg(t, mustReadWSChat(t, conn2), "user", "hello")
+
+	// the delivery receipt can come before or after the ack
+	var ack chat.AckMsg
+	mustReadWSTypes(t, conn2, map[string]any{"ack": &ack, "receipts": &chat.ReceiptsMsg{}})
+	if ack.ID != 7 {
+		t.Fatalf("expected ack for command 7, got %d", ack.ID)
+	}
+
+	// 2) Plain text frames are still chat messages
+	mustSendWSText(t, conn2, "plain")
+
+	assertChatMsg(t, mustReadWSChat(t, conn1), "user", "plain")
+	assertChatMsg(t, mustReadWSChat(t, conn2), "user", "plain")
+}
+
+func Test_Commands_ErrorReplies(t *testing.T) {
+	_, conn2 := newCommandConns(t)
+
+	type testCase struct {
+		name           string
+		frame          string
+		expectedID     uint32
+		expectedErrMsg string
+	}
+
+	tests := []testCase{
+		{
+			name:           "Unknown command",
+			frame:          `{"type":"dance","id":1}`,
+			expectedID:     1,
+			expectedErrMsg: "Unknown command.",
+		},
+		{
+			name:           "Message without content",
+			frame:          `{"type":"msg","id":2,"data":{}}`,
+			expectedID:     2,
+			expectedErrMsg: "Message content is required in the command.",
+		},
+		{
+			name:           "Missing permission",
+			frame:          `{"type":"lock","id":3,"data":{"locked":true}}`,
+			expectedID:     3,
+			expectedErrMsg: "You don't have permission to lock this room.",
+		},
+		{
+			name:           "Error without command ID",
+			frame:          `{"type":"dance"}`,
+			expectedID:     0,
+			expectedErrMsg: "Unknown command.",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mustSendWSText(t, conn2, tc.frame)
+
+			var errMsg chat.ErrorMsg
+			mustReadWSType(t, conn2, "error", &errMsg)
+
+			if errMsg.ID != tc.expectedID || errMsg.Message != tc.expectedErrMsg {
+				t.Fatalf("[%s] expected error %d %q, got %d %q", tc.name, tc.expectedID, tc.expectedErrMsg, errMsg.ID, errMsg.Message)
+			}
+		})
+	}
+}
+
+func Test_Commands_AckOnlyWithID(t *testing.T) {
+	conn1, _ := newCommandConns(t)
+
+	// the lock without an ID gets no ack, the lock change is the next message
+	mustSendWSText(t, conn1, `{"type":"lock","data":{"locked":true}}`)
+
+	var lock chat.LockMsg
+	mustReadWSType(t, conn1, "lock-changed", &lock)
+
+	mustSendWSText(t, conn1, `{"type":"lock","id":9,"data":{"locked":false}}`)
+
+	mustReadWSType(t, conn1, "lock-changed", &lock)
+	if lock.Locked {
+		t.Fatal("expected the room to be unlocked")
+	}
+
+	var ack chat.AckMsg
+	mustReadWSType(t, conn1, "ack", &ack)
+	if ack.ID != 9 {
+		t.Fatalf("expected ack for command 9, got %d", ack.ID)
+	}
+}
+
+func Test_Commands_MsgMeta(t *testing.T) {
+	conn1, conn2 := newCommandConns(t)
+
+	// 1) The ack of the msg command holds the same metadata as the broadcast message
+	before := time.Now().UnixMilli()
+	mustSendWSText(t, conn2, `{"type":"msg","id":1,"data":{"content":"first"}}`)
+
+	first := mustReadWSChat(t, conn1)
+	mustReadWSChat(t, conn2)
+
+	var ack chat.AckMsg
+	mustReadWSTypes(t, conn2, map[string]any{"ack": &ack, "receipts": &chat.ReceiptsMsg{}})
+	if ack.Msg == nil || *ack.Msg != first.MsgMeta {
+		t.Fatalf("expected ack with %+v, got %+v", first.MsgMeta, ack.Msg)
+	}
+	if first.Seq != 1 || first.MsgID == "" || first.SentAt < before || first.SentAt > time.Now().UnixMilli() {
+		t.Fatalf("unexpected message metadata: %+v", first.MsgMeta)
+	}
+
+	// 2) Plain text messages get the next sequence number and a new ID
+	mustSendWSText(t, conn1, "second")
+
+	second := mustReadWSChat(t, conn1)
+	mustReadWSChat(t, conn2)
+	if second.Seq != 2 || second.MsgID == first.MsgID {
+		t.Fatalf("unexpected message metadata: %+v", second.MsgMeta)
+	}
+}
+
+func Test_Commands_MsgSeqOrder(t *testing.T) {
+	defaultBurst := config.MessageBurst
+	config.MessageBurst = 100
+	defer func() { config.MessageBurst = defaultBurst }()
+
+	conn1, conn2 := newCommandConns(t)
+
+	const perSender = 8
+
+	// both participants post at the same time
+	var wg sync.WaitGroup
+	for _, conn := range []net.Conn{conn1, conn2} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perSender {
+				wsutil.WriteClientText(conn, []byte(fmt.Sprintf("msg %d", i)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// everyone gets the messages in the same order with sequence numbers going up by one
+	for _, conn := range []net.Conn{conn1, conn2} {
+		for i := range 2 * perSender {
+			msg := mustReadWSChat(t, conn)
+			if msg.Seq != uint64(i+1) {
+				t.Fatalf("expected seq %d, got %d", i+1, msg.Seq)
+			}
+		}
+	}
+}
@@ -1,15 +1,19 @@
 package chat_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
+	"kseli/auth"
 	"kseli/common"
 	"kseli/config"
+	"kseli/features/chat"
 	"kseli/router"
 )
 
@@ -122,7 +126,7 @@ func Test_CreateRoom_SessionIDValidation(t *testing.T) {
 func Test_CreateRoom_BadRequests(t *testing.T) {
 	mux := newCreateEnv()
 
-	const maxBody = 128
+	const maxBody = 512
 
 	type testCase struct {
 		name           string
@@ -157,14 +161,14 @@ func Test_CreateRoom_BadRequests(t *testing.T) {
 			expectedErrMsg: "Invalid JSON request body.",
 		},
 		{
-			name:           "Bad request: float in uint8",
+			name:           "Bad request: float in uint16",
 			body:           strings.NewReader(`{"username":"abc","maxParticipants":2.5}`),
 			expectedStatus: http.StatusBadRequest,
 			expectedErrMsg: "Invalid JSON request body.",
 		},
 		{
-			name:           "Bad request: overflow uint8",
-			body:           strings.NewReader(`{"username":"abc","maxParticipants":300}`),
+			name:           "Bad request: overflow uint16",
+			body:           strings.NewReader(`{"username":"abc","maxParticipants":70000}`),
 			expectedStatus: http.StatusBadRequest,
 			expectedErrMsg: "Invalid JSON request body.",
 		},
@@ -300,7 +304,7 @@ func Test_CreateRoom_MaxParticipantsValidation(t *testing.T) {
 
 	type testCase struct {
 		name                         string
-		maxParticipants              uint8
+		maxParticipants              uint16
 		expectedStatus               int
 		expectedMaxParticipantsError string
 	}
@@ -351,3 +355,118 @@ func Test_CreateRoom_MaxParticipantsValidation(t *testing.T) {
 		})
 	}
 }
+
+func Test_CreateRoom_DurationValidation(t *testing.T) {
+	mux := newCreateEnv()
+
+	type testCase struct {
+		name                  string
+		durationMinutes       uint16
+		expectedStatus        int
+		expectedDurationError string
+	}
+
+	tests := []testCase{
+		{
+			name:                  "Valid durationMinutes: omitted",
+			durationMinutes:       0,
+			expectedStatus:        http.StatusCreated,
+			expectedDurationError: "",
+		},
+		{
+			name:                  "Valid durationMinutes: equals 5",
+			durationMinutes:       5,
+			expectedStatus:        http.StatusCreated,
+			expectedDurationError: "",
+		},
+		{
+			name:                  "Valid durationMinutes: equals 120",
+			durationMinutes:       120,
+			expectedStatus:        http.StatusCreated,
+			expectedDurationError: "",
+		},
+		{
+			name:                  "Invalid durationMinutes: less than 5",
+			durationMinutes:       4,
+			expectedStatus:        http.StatusBadRequest,
+			expectedDurationError: "Duration must be between 5 and 120 minutes.",
+		},
+		{
+			name:                  "Invalid durationMinutes: more than 120",
+			durationMinutes:       121,
+			expectedStatus:        http.StatusBadRequest,
+			expectedDurationError: "Duration must be between 5 and 120 minutes.",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := map[string]string{
+				"Origin":                   "http://kseli.app",
+				"X-Api-Key":                config.APIKey,
+				"X-Participant-Session-Id": "admin",
+			}
+			body, _ := json.Marshal(chat.CreateRoomRequest{
+				Username:        "admin",
+				MaxParticipants: 3,
+				DurationMinutes: tc.durationMinutes,
+			})
+
+			status, respBody := sendRequest(mux, http.MethodPost, "/api/rooms", bytes.NewReader(body), headers)
+
+			if status != tc.expectedStatus {
+				t.Fatalf("[%s] expected %d, got %d, body: %s", tc.name, tc.expectedStatus, status, string(respBody))
+			}
+
+			if tc.expectedStatus != http.StatusCreated {
+				var errResp common.ErrorResponse
+				if err := json.Unmarshal(respBody, &errResp); err != nil {
+					t.Fatalf("[%s] failed to unmarshal: %v", tc.name, err)
+				}
+
+				errMsg, ok := errResp.FieldErrors["durationMinutes"]
+				if !ok {
+					t.Fatalf("[%s] expected field error for 'durationMinutes', but none found", tc.name)
+				}
+
+				if errMsg != tc.expectedDurationError {
+					t.Fatalf("[%s] expected field error message %q, got %q", tc.name, tc.expectedDurationError, errMsg)
+				}
+				return
+			}
+
+			var resp chat.CreateRoomResponse
+			if err := json.Unmarshal(respBody, &resp); err != nil {
+				t.Fatalf("[%s] failed to unmarshal: %v", tc.name, err)
+			}
+
+			lifetime := config.RoomLifetime
+			if tc.durationMinutes != 0 {
+				lifetime = time.Duration(tc.durationMinutes) * time.Minute
+			}
+
+			claims, err := auth.ValidateToken[auth.Claims](resp.Token)
+			if err != nil {
+				t.Fatalf("[%s] expected a valid token, got error: %v", tc.name, err)
+			}
+
+			expectedExp := time.Now().Add(lifetime).Unix()
+			if claims.Exp < expectedExp-1 || claims.Exp > expectedExp {
+				t.Fatalf("[%s] expected token to expire around %d, got %d", tc.name, expectedExp, claims.Exp)
+			}
+
+			inviteToken, getResp, _ := getRoom(t, true, true, 0, mux, resp.RoomID, "http://kseli.app", resp.Token)
+			if getResp.ExpiresAt != claims.Exp {
+				t.Fatalf("[%s] expected room to expire at %d, got %d", tc.name, claims.Exp, getResp.ExpiresAt)
+			}
+
+			inviteClaims, err := auth.ValidateToken[auth.InviteClaims](inviteToken)
+			if err != nil {
+				t.Fatalf("[%s] expected a valid invite token, got error: %v", tc.name, err)
+			}
+			if inviteClaims.Exp != claims.Exp {
+				t.Fatalf("[%s] expected invite token to expire at %d, got %d", tc.name, claims.Exp, inviteClaims.Exp)
+			}
+		})
+	}
+}
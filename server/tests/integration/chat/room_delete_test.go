@@ -1,13 +1,18 @@
 package chat_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"sync"
 	"testing"
 	"time"
 
 	"kseli/auth"
 	"kseli/common"
 	"kseli/config"
+	"kseli/features/chat"
 	"kseli/router"
 )
 
@@ -170,7 +175,7 @@ func Test_DeleteRoom_NotAdmin(t *testing.T) {
 
 	errResp := deleteRoom(t, false, 403, env.mux, env.roomID, "http://kseli.app", env.regularToken)
 
-	expectedErrMsg := "You are not an admin and can't close this room."
+	expectedErrMsg := "You don't have permission to close this room."
 	if errResp.Message != expectedErrMsg {
 		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
 	}
@@ -221,3 +226,50 @@ func Test_DeleteRoom_RoomIDValidation(t *testing.T) {
 		})
 	}
 }
+
+func Test_DeleteRoom_ConcurrentJoins(t *testing.T) {
+	defaultMax := config.MaxRoomParticipants
+	config.MaxRoomParticipants = 50
+	defer func() { config.MaxRoomParticipants = defaultMax }()
+
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	createResp, _ := createRoom(t, true, 0, mux, 50, "admin", "http://kseli.app", config.APIKey, "admin")
+	inviteToken, _, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+
+	// joins racing with the close must either get in before it or be refused, never hit the torn down room
+	const joiners = 30
+	statuses := make(chan int, joiners)
+
+	var wg sync.WaitGroup
+	for i := range joiners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			headers := map[string]string{
+				"Origin":                   "http://kseli.app",
+				"Authorization":            inviteToken,
+				"X-Participant-Session-Id": fmt.Sprintf("user%d", i),
+			}
+			body, _ := json.Marshal(chat.JoinRoomRequest{
+				Username: fmt.Sprintf("user%d", i),
+			})
+
+			status, _ := sendRequest(mux, http.MethodPost, "/api/rooms/join", bytes.NewReader(body), headers)
+			statuses <- status
+		}()
+	}
+
+	deleteRoom(t, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+
+	wg.Wait()
+	close(statuses)
+
+	for status := range statuses {
+		if status != http.StatusCreated && status != http.StatusNotFound && status != http.StatusGone {
+			t.Fatalf("expected join to return 201, 404 or 410, got %d", status)
+		}
+	}
+}
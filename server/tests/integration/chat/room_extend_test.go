@@ -0,0 +1,201 @@
+package chat_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kseli/auth"
+	"kseli/config"
+	"kseli/features/chat"
+	"kseli/router"
+)
+
+type extendEnv struct {
+	roomID       string
+	adminToken   string
+	regularToken string
+	expiresAt    int64
+	mux          *http.ServeMux
+}
+
+func newExtendEnv(t *testing.T) *extendEnv {
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	// 1) Create the room to get the admin token
+	createResp, _ := createRoom(t, true, 0, mux, 2, "admin", "http://kseli.app", config.APIKey, "admin")
+
+	// 2) Fetch invite token via get room as an admin
+	inviteToken, getResp, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+
+	// 3) Join the room to get the regular token
+	joinResp, _ := joinRoom(t, true, 0, mux, "user", "http://kseli.app", inviteToken, "user")
+
+	return &extendEnv{
+		roomID:       createResp.RoomID,
+		adminToken:   createResp.Token,
+		regularToken: joinResp.Token,
+		expiresAt:    getResp.ExpiresAt,
+		mux:          mux,
+	}
+}
+
+func Test_ExtendRoom_Success(t *testing.T) {
+	env := newExtendEnv(t)
+
+	resp, _ := extendRoom(t, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+
+	expectedExpiresAt := env.expiresAt + int64(config.RoomExtension.Seconds())
+	if resp.ExpiresAt != expectedExpiresAt {
+		t.Fatalf("expected expiresAt %d, got %d", expectedExpiresAt, resp.ExpiresAt)
+	}
+
+	claims, err := auth.ValidateToken[auth.Claims](resp.Token)
+	if err != nil {
+		t.Fatalf("expected a valid reissued token, got error: %v", err)
+	}
+
+	if claims.Exp != resp.ExpiresAt {
+		t.Fatalf("expected reissued token to expire at %d, got %d", resp.ExpiresAt, claims.Exp)
+	}
+
+	// the room details and the invite link must reflect the new expiration
+	inviteToken, getResp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", resp.Token)
+
+	if getResp.ExpiresAt != resp.ExpiresAt {
+		t.Fatalf("expected room expiresAt %d, got %d", resp.ExpiresAt, getResp.ExpiresAt)
+	}
+
+	inviteClaims, err := auth.ValidateToken[auth.InviteClaims](inviteToken)
+	if err != nil {
+		t.Fatalf("expected a valid invite token, got error: %v", err)
+	}
+
+	if inviteClaims.Exp != resp.ExpiresAt {
+		t.Fatalf("expected invite token to expire at %d, got %d", resp.ExpiresAt, inviteClaims.Exp)
+	}
+}
+
+func Test_ExtendRoom_JoinAfterExtend(t *testing.T) {
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	createResp, _ := createRoom(t, true, 0, mux, 2, "admin", "http://kseli.app", config.APIKey, "admin")
+	resp, _ := extendRoom(t, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+
+	// the main invite link was reissued by the extension
+	inviteToken, _, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+	joinResp, _ := joinRoom(t, true, 0, mux, "user", "http://kseli.app", inviteToken, "user")
+
+	claims, err := auth.ValidateToken[auth.Claims](joinResp.Token)
+	if err != nil {
+		t.Fatalf("expected a valid token, got error: %v", err)
+	}
+	if claims.Exp != resp.ExpiresAt {
+		t.Fatalf("expected token of the new member to expire with the room at %d, got %d", resp.ExpiresAt, claims.Exp)
+	}
+}
+
+func Test_ExtendRoom_MaxLifetimeReached(t *testing.T) {
+	env := newExtendEnv(t)
+
+	maxExpiresAt := env.expiresAt - int64(config.RoomLifetime.Seconds()) + int64(config.MaxRoomLifetime.Seconds())
+
+	var resp chat.ExtendRoomResponse
+	for resp.ExpiresAt < maxExpiresAt {
+		resp, _ = extendRoom(t, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	}
+
+	if resp.ExpiresAt != maxExpiresAt {
+		t.Fatalf("expected expiresAt to be capped at %d, got %d", maxExpiresAt, resp.ExpiresAt)
+	}
+
+	_, errResp := extendRoom(t, false, http.StatusConflict, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+
+	expectedErrMsg := "Chat Room has reached its maximum lifetime and can't be extended."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_ExtendRoom_RoomNotFound(t *testing.T) {
+	env := newExtendEnv(t)
+
+	_, errResp := extendRoom(t, false, http.StatusNotFound, env.mux, "invalid-room-id", "http://kseli.app", env.adminToken)
+
+	expectedErrMsg := "Chat Room not found."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_ExtendRoom_AccessForbidden(t *testing.T) {
+	env := newExtendEnv(t)
+
+	// 1) Create a new room (to get different claims and try to extend using that)
+	createResp, _ := createRoom(t, true, 0, env.mux, 2, "admin", "http://kseli.app", config.APIKey, "admin")
+
+	// 2) Try to extend the new room using the token of the first one
+	_, errResp := extendRoom(t, false, http.StatusForbidden, env.mux, createResp.RoomID, "http://kseli.app", env.adminToken)
+
+	expectedErrMsg := "You do not have access to this room."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_ExtendRoom_NotAdmin(t *testing.T) {
+	env := newExtendEnv(t)
+
+	_, errResp := extendRoom(t, false, http.StatusForbidden, env.mux, env.roomID, "http://kseli.app", env.regularToken)
+
+	expectedErrMsg := "You don't have permission to extend this room."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_ExtendRoom_WSMessagesReceived(t *testing.T) {
+	env := newExtendEnv(t)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	// drain join messages, the admin join is read before the user connects so only the admin gets it
+	conn1 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	conn2 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.regularToken)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+
+	resp, _ := extendRoom(t, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+
+	for _, conn := range []net.Conn{conn1, conn2} {
+		var token chat.TokenMsg
+		mustReadWSType(t, conn, "token", &token)
+
+		claims, err := auth.ValidateToken[auth.Claims](token.Token)
+		if err != nil {
+			t.Fatalf("expected a valid reissued token, got error: %v", err)
+		}
+		if claims.Exp != resp.ExpiresAt {
+			t.Fatalf("expected reissued token to expire at %d, got %d", resp.ExpiresAt, claims.Exp)
+		}
+
+		var expiry chat.ExpiryMsg
+		mustReadWSType(t, conn, "expiry-changed", &expiry)
+
+		if expiry.ExpiresAt != resp.ExpiresAt {
+			t.Fatalf("expected expiresAt %d, got %d", resp.ExpiresAt, expiry.ExpiresAt)
+		}
+	}
+
+	if time.Unix(resp.ExpiresAt, 0).Before(time.Now()) {
+		t.Fatalf("expected expiresAt in the future, got %d", resp.ExpiresAt)
+	}
+}
@@ -0,0 +1,193 @@
+package chat_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kseli/config"
+	"kseli/features/chat"
+	"kseli/router"
+)
+
+type historyEnv struct {
+	roomID      string
+	adminToken  string
+	inviteToken string
+	mux         *http.ServeMux
+	serverAddr  string
+}
+
+func newHistoryEnv(t *testing.T, history bool) *historyEnv {
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	// 1) Create the room with or without the history
+	headers := map[string]string{
+		"Origin":                   "http://kseli.app",
+		"X-Api-Key":                config.APIKey,
+		"X-Participant-Session-Id": "admin",
+	}
+	body, _ := json.Marshal(chat.CreateRoomRequest{
+		Username:        "admin",
+		MaxParticipants: 3,
+		History:         history,
+	})
+
+	status, respBody := sendRequest(mux, http.MethodPost, "/api/rooms", bytes.NewReader(body), headers)
+	if status != http.StatusCreated {
+		t.Fatalf("expected 201, got %d, body: %s", status, string(respBody))
+	}
+
+	var createResp chat.CreateRoomResponse
+	if err := json.Unmarshal(respBody, &createResp); err != nil {
+		t.Fatalf("failed to unmarshal success resp: %v", err)
+	}
+
+	// 2) Fetch invite token via get room as an admin
+	inviteToken, getResp, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+	if getResp.History != history {
+		t.Fatalf("expected history %v, got %v", history, getResp.History)
+	}
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return &historyEnv{
+		roomID:      createResp.RoomID,
+		adminToken:  createResp.Token,
+		inviteToken: inviteToken,
+		mux:         mux,
+		serverAddr:  server.Listener.Addr().String(),
+	}
+}
+
+// postMessages sends the messages as the connected participant and reads them back
+func postMessages(t *testing.T, conn net.Conn, n int) {
+	t.Helper()
+
+	for i := range n {
+		mustSendWSText(t, conn, fmt.Sprintf("msg %d", i+1))
+		mustReadWSChat(t, conn)
+	}
+}
+
+func mustRequestHistory(t *testing.T, conn net.Conn, req string) chat.HistoryMsg {
+	t.Helper()
+
+	mustSendWSText(t, conn, `{"type":"history","data":`+req+`}`)
+
+	var history chat.HistoryMsg
+	mustReadWSType(t, conn, "history", &history)
+
+	return history
+}
+
+func assertHistorySeqs(t *testing.T, history chat.HistoryMsg, from, to uint64) {
+	t.Helper()
+
+	if len(history.Messages) != int(to-from+1) {
+		t.Fatalf("expected messages %d to %d, got %+v", from, to, history.Messages)
+	}
+	for i, msg := range history.Messages {
+		if msg.Seq != from+uint64(i) || msg.Content != fmt.Sprintf("msg %d", msg.Seq) {
+			t.Fatalf("expected message %d, got %+v", from+uint64(i), msg)
+		}
+	}
+}
+
+func Test_History_Disabled(t *testing.T) {
+	env := newHistoryEnv(t, false)
+
+	conn := dialWS(t, "ws://"+env.serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn)
+
+	mustSendWSText(t, conn, `{"type":"history","id":1}`)
+
+	var errMsg chat.ErrorMsg
+	mustReadWSType(t, conn, "error", &errMsg)
+
+	expectedErrMsg := "This room doesn't keep a message history."
+	if errMsg.ID != 1 || errMsg.Message != expectedErrMsg {
+		t.Fatalf("expected error %q for command 1, got %+v", expectedErrMsg, errMsg)
+	}
+}
+
+func Test_History_LateJoiner(t *testing.T) {
+	env := newHistoryEnv(t, true)
+
+	conn1 := dialWS(t, "ws://"+env.serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	postMessages(t, conn1, 3)
+
+	// the new joiner asks for the last two messages
+	joinResp, _ := joinRoom(t, true, 0, env.mux, "user", "http://kseli.app", env.inviteToken, "user")
+	conn2 := dialWS(t, "ws://"+env.serverAddr+"/ws/room?token="+joinResp.Token)
+	mustReadWSJoin(t, conn2)
+
+	assertHistorySeqs(t, mustRequestHistory(t, conn2, `{"limit":2}`), 2, 3)
+
+	// without a limit the whole history is sent
+	assertHistorySeqs(t, mustRequestHistory(t, conn2, `{}`), 1, 3)
+}
+
+func Test_History_Resume(t *testing.T) {
+	env := newHistoryEnv(t, true)
+
+	conn := dialWS(t, "ws://"+env.serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn)
+
+	postMessages(t, conn, 4)
+
+	// 1) The client had everything up to message 2
+	history := mustRequestHistory(t, conn, `{"afterSeq":2}`)
+	assertHistorySeqs(t, history, 3, 4)
+	if history.Truncated {
+		t.Fatal("expected the history not to be truncated")
+	}
+
+	// 2) The client is up to date
+	history = mustRequestHistory(t, conn, `{"afterSeq":4}`)
+	if len(history.Messages) != 0 {
+		t.Fatalf("expected no messages, got %+v", history.Messages)
+	}
+
+	// 3) A seq past the newest message gets nothing, even at the very top of the range
+	for _, afterSeq := range []string{"5", "9223372036854775808", "18446744073709551615"} {
+		history = mustRequestHistory(t, conn, `{"afterSeq":`+afterSeq+`}`)
+		if len(history.Messages) != 0 || history.Truncated {
+			t.Fatalf("expected no messages after %s, got %+v", afterSeq, history)
+		}
+	}
+}
+
+func Test_History_Overflow(t *testing.T) {
+	defaultSize := config.HistorySize
+	config.HistorySize = 3
+	defer func() { config.HistorySize = defaultSize }()
+
+	env := newHistoryEnv(t, true)
+
+	conn := dialWS(t, "ws://"+env.serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn)
+
+	postMessages(t, conn, 5)
+
+	// only the last three messages are kept, the client missed some for good
+	history := mustRequestHistory(t, conn, `{"afterSeq":1}`)
+	assertHistorySeqs(t, history, 3, 5)
+	if !history.Truncated {
+		t.Fatal("expected the history to be truncated")
+	}
+
+	history = mustRequestHistory(t, conn, `{"afterSeq":2}`)
+	assertHistorySeqs(t, history, 3, 5)
+	if history.Truncated {
+		t.Fatal("expected the history not to be truncated")
+	}
+}
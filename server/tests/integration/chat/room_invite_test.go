@@ -0,0 +1,298 @@
+package chat_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kseli/auth"
+	"kseli/common"
+	"kseli/config"
+	"kseli/features/chat"
+	"kseli/router"
+)
+
+type inviteEnv struct {
+	roomID       string
+	adminToken   string
+	regularToken string
+	inviteToken  string
+	mux          *http.ServeMux
+}
+
+func newInviteEnv(t *testing.T) *inviteEnv {
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	// 1) Create the room to get the admin token
+	createResp, _ := createRoom(t, true, 0, mux, 3, "admin", "http://kseli.app", config.APIKey, "admin")
+
+	// 2) Fetch invite token via get room as an admin
+	inviteToken, _, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+
+	// 3) Join the room to get the regular token
+	joinResp, _ := joinRoom(t, true, 0, mux, "user", "http://kseli.app", inviteToken, "user")
+
+	return &inviteEnv{
+		roomID:       createResp.RoomID,
+		adminToken:   createResp.Token,
+		regularToken: joinResp.Token,
+		inviteToken:  inviteToken,
+		mux:          mux,
+	}
+}
+
+func Test_RotateInvite_Success(t *testing.T) {
+	env := newInviteEnv(t)
+
+	newInviteToken, _ := rotateInvite(t, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+
+	// the old invite link can't be used anymore
+	_, errResp := joinRoom(t, false, http.StatusForbidden, env.mux, "user2", "http://kseli.app", env.inviteToken, "user2")
+
+	expectedErrMsg := "Invalid invite link."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+
+	// the room details hold the new link
+	inviteToken, _, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if inviteToken != newInviteToken {
+		t.Fatalf("expected room invite token %q, got %q", newInviteToken, inviteToken)
+	}
+
+	joinRoom(t, true, 0, env.mux, "user2", "http://kseli.app", newInviteToken, "user2")
+}
+
+func Test_RotateInvite_NotAllowed(t *testing.T) {
+	env := newInviteEnv(t)
+
+	_, errResp := rotateInvite(t, false, http.StatusForbidden, env.mux, env.roomID, "http://kseli.app", env.regularToken)
+
+	expectedErrMsg := "You don't have permission to change the invite link of this room."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+
+	// moderators can invite but rotating the link would revoke the invites the admin minted
+	setRole(t, true, 0, env.mux, 2, common.Moderator, env.roomID, "http://kseli.app", env.adminToken)
+
+	_, errResp = rotateInvite(t, false, http.StatusForbidden, env.mux, env.roomID, "http://kseli.app", env.regularToken)
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_RotateInvite_RoomNotFound(t *testing.T) {
+	env := newInviteEnv(t)
+
+	_, errResp := rotateInvite(t, false, http.StatusNotFound, env.mux, "invalid-room-id", "http://kseli.app", env.adminToken)
+
+	expectedErrMsg := "Chat Room not found."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_RotateInvite_WSMessageReceived(t *testing.T) {
+	env := newInviteEnv(t)
+
+	// the moderator can invite and must get the new link
+	setRole(t, true, 0, env.mux, 2, common.Moderator, env.roomID, "http://kseli.app", env.adminToken)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	// drain join messages, the admin join is read before the user connects so only the admin gets it
+	conn1 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	conn2 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.regularToken)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+
+	newInviteToken, _ := rotateInvite(t, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+
+	var invite chat.InviteMsg
+	mustReadWSType(t, conn2, "invite-changed", &invite)
+
+	if !strings.HasSuffix(invite.InviteLink, "#invite="+newInviteToken) {
+		t.Fatalf("expected invite link with token %q, got %q", newInviteToken, invite.InviteLink)
+	}
+}
+
+func Test_CreateInvite_LimitedUses(t *testing.T) {
+	env := newInviteEnv(t)
+
+	createResp, inviteToken, _ := createInvite(t, true, 0, env.mux, chat.CreateInviteRequest{
+		Label:   "for alice",
+		MaxUses: 1,
+	}, env.roomID, "http://kseli.app", env.adminToken)
+
+	joinRoom(t, true, 0, env.mux, "alice", "http://kseli.app", inviteToken, "alice")
+
+	// the invite is used up
+	_, errResp := joinRoom(t, false, http.StatusForbidden, env.mux, "bob", "http://kseli.app", inviteToken, "bob")
+
+	expectedErrMsg := "This invite link has been used up."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+
+	invitesResp := getInvites(t, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if len(invitesResp.Invites) != 1 {
+		t.Fatalf("expected 1 invite, got %d", len(invitesResp.Invites))
+	}
+
+	inv := invitesResp.Invites[0]
+	if inv.ID != createResp.InviteID || inv.Label != "for alice" || inv.MaxUses != 1 || inv.Uses != 1 {
+		t.Fatalf("unexpected invite %+v", inv)
+	}
+
+	// the admin sees which invite each participant joined with
+	_, getResp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	for _, p := range getResp.Participants {
+		expectedInviteID := ""
+		if p.Username == "alice" {
+			expectedInviteID = createResp.InviteID
+		}
+		if p.InviteID != expectedInviteID {
+			t.Fatalf("expected %s to have joined with invite %q, got %q", p.Username, expectedInviteID, p.InviteID)
+		}
+	}
+
+	// regular participants don't see it
+	_, getResp, _ = getRoom(t, true, false, 0, env.mux, env.roomID, "http://kseli.app", env.regularToken)
+	for _, p := range getResp.Participants {
+		if p.InviteID != "" {
+			t.Fatalf("expected no invite ID for regular participant, got %q", p.InviteID)
+		}
+	}
+}
+
+func Test_CreateInvite_ShortExpiry(t *testing.T) {
+	env := newInviteEnv(t)
+
+	createResp, inviteToken, _ := createInvite(t, true, 0, env.mux, chat.CreateInviteRequest{
+		ExpiresInMinutes: 5,
+	}, env.roomID, "http://kseli.app", env.adminToken)
+
+	expectedExp := time.Now().Add(5 * time.Minute).Unix()
+	if createResp.ExpiresAt < expectedExp-1 || createResp.ExpiresAt > expectedExp {
+		t.Fatalf("expected invite to expire around %d, got %d", expectedExp, createResp.ExpiresAt)
+	}
+
+	inviteClaims, err := auth.ValidateToken[auth.InviteClaims](inviteToken)
+	if err != nil {
+		t.Fatalf("expected a valid invite token, got error: %v", err)
+	}
+	if inviteClaims.Exp != createResp.ExpiresAt || inviteClaims.InviteID != createResp.InviteID {
+		t.Fatalf("unexpected invite claims %+v", inviteClaims)
+	}
+
+	// an invite can't outlive the room
+	createResp, _, _ = createInvite(t, true, 0, env.mux, chat.CreateInviteRequest{
+		ExpiresInMinutes: 600,
+	}, env.roomID, "http://kseli.app", env.adminToken)
+
+	_, getResp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if createResp.ExpiresAt != getResp.ExpiresAt {
+		t.Fatalf("expected invite to expire with the room at %d, got %d", getResp.ExpiresAt, createResp.ExpiresAt)
+	}
+}
+
+func Test_CreateInvite_ExtendedWithRoom(t *testing.T) {
+	env := newInviteEnv(t)
+
+	createResp, inviteToken, _ := createInvite(t, true, 0, env.mux, chat.CreateInviteRequest{}, env.roomID, "http://kseli.app", env.adminToken)
+	shortResp, _, _ := createInvite(t, true, 0, env.mux, chat.CreateInviteRequest{
+		ExpiresInMinutes: 5,
+	}, env.roomID, "http://kseli.app", env.adminToken)
+
+	extendResp, _ := extendRoom(t, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+
+	// the invite minted without a lifetime keeps expiring with the room, the short one is untouched
+	invitesResp := getInvites(t, env.mux, env.roomID, "http://kseli.app", extendResp.Token)
+	for _, inv := range invitesResp.Invites {
+		switch inv.ID {
+		case createResp.InviteID:
+			if inv.ExpiresAt != extendResp.ExpiresAt {
+				t.Fatalf("expected invite to expire with the room at %d, got %d", extendResp.ExpiresAt, inv.ExpiresAt)
+			}
+		case shortResp.InviteID:
+			if inv.ExpiresAt != shortResp.ExpiresAt {
+				t.Fatalf("expected short invite to still expire at %d, got %d", shortResp.ExpiresAt, inv.ExpiresAt)
+			}
+		}
+	}
+
+	inviteClaims, err := auth.ValidateToken[auth.InviteClaims](inviteToken)
+	if err != nil {
+		t.Fatalf("expected a valid invite token, got error: %v", err)
+	}
+	if inviteClaims.Exp < extendResp.ExpiresAt {
+		t.Fatalf("expected invite token to outlive the extended room expiry %d, got %d", extendResp.ExpiresAt, inviteClaims.Exp)
+	}
+
+	joinResp, _ := joinRoom(t, true, 0, env.mux, "user2", "http://kseli.app", inviteToken, "user2")
+
+	claims, err := auth.ValidateToken[auth.Claims](joinResp.Token)
+	if err != nil {
+		t.Fatalf("expected a valid token, got error: %v", err)
+	}
+	if claims.Exp != extendResp.ExpiresAt {
+		t.Fatalf("expected token of the new member to expire with the room at %d, got %d", extendResp.ExpiresAt, claims.Exp)
+	}
+}
+
+func Test_CreateInvite_InvalidatedByRotation(t *testing.T) {
+	env := newInviteEnv(t)
+
+	_, inviteToken, _ := createInvite(t, true, 0, env.mux, chat.CreateInviteRequest{}, env.roomID, "http://kseli.app", env.adminToken)
+
+	rotateInvite(t, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+
+	_, errResp := joinRoom(t, false, http.StatusForbidden, env.mux, "user2", "http://kseli.app", inviteToken, "user2")
+
+	expectedErrMsg := "Invalid invite link."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_CreateInvite_MultiByteLabel(t *testing.T) {
+	env := newInviteEnv(t)
+
+	// 30 runes is the longest allowed label, the limit is in characters not bytes
+	for _, label := range []string{strings.Repeat("友", 30), strings.Repeat("🔑", 30)} {
+		createInvite(t, true, 0, env.mux, chat.CreateInviteRequest{
+			Label:            label,
+			MaxUses:          5,
+			ExpiresInMinutes: 10,
+			Spectator:        true,
+		}, env.roomID, "http://kseli.app", env.adminToken)
+	}
+}
+
+func Test_CreateInvite_BadRequests(t *testing.T) {
+	env := newInviteEnv(t)
+
+	_, _, errResp := createInvite(t, false, http.StatusBadRequest, env.mux, chat.CreateInviteRequest{
+		Label: strings.Repeat("a", 31),
+	}, env.roomID, "http://kseli.app", env.adminToken)
+
+	expectedErrMsg := "Label can't be longer than 30 characters."
+	if errResp.FieldErrors["label"] != expectedErrMsg {
+		t.Fatalf("expected field error message %q, got %q", expectedErrMsg, errResp.FieldErrors["label"])
+	}
+
+	_, _, errResp = createInvite(t, false, http.StatusForbidden, env.mux, chat.CreateInviteRequest{}, env.roomID, "http://kseli.app", env.regularToken)
+
+	expectedErrMsg = "You don't have permission to invite anyone to this room."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
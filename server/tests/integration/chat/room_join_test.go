@@ -1,17 +1,20 @@
 package chat_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"kseli/auth"
 	"kseli/common"
 	"kseli/config"
+	"kseli/features/chat"
 	"kseli/router"
 )
 
@@ -156,7 +159,7 @@ func Test_JoinRoom_InviteTokenValidation(t *testing.T) {
 func Test_JoinRoom_BadRequests(t *testing.T) {
 	env := newJoinEnv(t)
 
-	const maxBody = 128
+	const maxBody = 512
 
 	type testCase struct {
 		name           string
@@ -408,3 +411,132 @@ func Test_JoinRoom_UsernameValidation(t *testing.T) {
 		})
 	}
 }
+
+func Test_JoinRoom_LargeRoom(t *testing.T) {
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	defaultMax := config.MaxRoomParticipants
+	config.MaxRoomParticipants = 300
+	defer func() { config.MaxRoomParticipants = defaultMax }()
+
+	// 1) Create a room above the default limit
+	createResp, _ := createRoom(t, true, 0, mux, 300, "admin", "http://kseli.app", config.APIKey, "admin")
+	inviteToken, _, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+
+	// 2) Fill the room, participant IDs go past 255 without wrapping
+	var lastToken string
+	for i := 2; i <= 300; i++ {
+		name := fmt.Sprintf("user%d", i)
+		joinResp, _ := joinRoom(t, true, 0, mux, name, "http://kseli.app", inviteToken, name)
+		lastToken = joinResp.Token
+	}
+
+	claims, err := auth.ValidateToken[auth.Claims](lastToken)
+	if err != nil {
+		t.Fatalf("expected a valid token, got error: %v", err)
+	}
+	if claims.UserID != 300 {
+		t.Fatalf("expected last participant ID 300, got %d", claims.UserID)
+	}
+
+	// 3) The room is full
+	_, errResp := joinRoom(t, false, 409, mux, "user301", "http://kseli.app", inviteToken, "user301")
+	expectedErrMsg := "Chat Room is full."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+
+	// 4) Kick a participant with an ID above 255 and let someone else in
+	kickOrBanUser(t, true, 0, mux, 300, "kick", createResp.RoomID, "http://kseli.app", createResp.Token)
+	joinResp, _ := joinRoom(t, true, 0, mux, "user300", "http://kseli.app", inviteToken, "user301")
+
+	claims, _ = auth.ValidateToken[auth.Claims](joinResp.Token)
+	if claims.UserID != 301 {
+		t.Fatalf("expected new participant ID 301, got %d", claims.UserID)
+	}
+
+	_, getResp, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+	if len(getResp.Participants) != 300 {
+		t.Fatalf("expected 300 participants, got %d", len(getResp.Participants))
+	}
+}
+
+func Test_JoinRoom_Concurrent(t *testing.T) {
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	const maxParticipants = 5
+	const joiners = 30
+
+	createResp, _ := createRoom(t, true, 0, mux, maxParticipants, "admin", "http://kseli.app", config.APIKey, "admin")
+	inviteToken, _, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+
+	// sendJoins fires all joins at once and counts the response statuses
+	sendJoins := func(username func(i int) string, sessionID func(i int) string) map[int]int {
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			statuses = make(map[int]int)
+			start    = make(chan struct{})
+		)
+
+		for i := range joiners {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				headers := map[string]string{
+					"Origin":                   "http://kseli.app",
+					"Authorization":            inviteToken,
+					"X-Participant-Session-Id": sessionID(i),
+				}
+				body, _ := json.Marshal(chat.JoinRoomRequest{Username: username(i)})
+
+				<-start
+				status, _ := sendRequest(mux, http.MethodPost, "/api/rooms/join", bytes.NewReader(body), headers)
+
+				mu.Lock()
+				statuses[status]++
+				mu.Unlock()
+			}()
+		}
+
+		close(start)
+		wg.Wait()
+		return statuses
+	}
+
+	// 1) Everyone races for the same username, only one of them gets it
+	statuses := sendJoins(
+		func(i int) string { return "same" },
+		func(i int) string { return fmt.Sprintf("same-session-%d", i) },
+	)
+	if statuses[http.StatusCreated] != 1 || statuses[http.StatusBadRequest] != joiners-1 {
+		t.Fatalf("expected 1 join and %d taken usernames, got %v", joiners-1, statuses)
+	}
+
+	// 2) Everyone races for the remaining slots, the room doesn't overfill
+	statuses = sendJoins(
+		func(i int) string { return fmt.Sprintf("user%d", i) },
+		func(i int) string { return fmt.Sprintf("session-%d", i) },
+	)
+	freeSlots := maxParticipants - 2
+	if statuses[http.StatusCreated] != freeSlots || statuses[http.StatusConflict] != joiners-freeSlots {
+		t.Fatalf("expected %d joins and %d full rooms, got %v", freeSlots, joiners-freeSlots, statuses)
+	}
+
+	_, getResp, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+	if len(getResp.Participants) != maxParticipants {
+		t.Fatalf("expected %d participants, got %d", maxParticipants, len(getResp.Participants))
+	}
+
+	// 3) Participant IDs are unique
+	seen := make(map[uint32]bool, maxParticipants)
+	for _, p := range getResp.Participants {
+		if seen[p.ID] {
+			t.Fatalf("participant ID %d was given out twice", p.ID)
+		}
+		seen[p.ID] = true
+	}
+}
@@ -0,0 +1,209 @@
+package chat_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kseli/config"
+	"kseli/features/chat"
+	"kseli/router"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+type knockEnv struct {
+	roomID      string
+	adminToken  string
+	inviteToken string
+	mux         *http.ServeMux
+}
+
+func newKnockEnv(t *testing.T) *knockEnv {
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	// 1) Create the room in knock mode to get the admin token
+	headers := map[string]string{
+		"Origin":                   "http://kseli.app",
+		"X-Api-Key":                config.APIKey,
+		"X-Participant-Session-Id": "admin",
+	}
+	body, _ := json.Marshal(chat.CreateRoomRequest{
+		Username:        "admin",
+		MaxParticipants: 3,
+		Knock:           true,
+	})
+
+	status, respBody := sendRequest(mux, http.MethodPost, "/api/rooms", bytes.NewReader(body), headers)
+	if status != http.StatusCreated {
+		t.Fatalf("expected 201, got %d, body: %s", status, string(respBody))
+	}
+
+	var createResp chat.CreateRoomResponse
+	if err := json.Unmarshal(respBody, &createResp); err != nil {
+		t.Fatalf("failed to unmarshal success resp: %v", err)
+	}
+
+	// 2) Fetch invite token via get room as an admin
+	inviteToken, _, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+
+	return &knockEnv{
+		roomID:      createResp.RoomID,
+		adminToken:  createResp.Token,
+		inviteToken: inviteToken,
+		mux:         mux,
+	}
+}
+
+// mustKnock sends a join request that has to be parked as pending
+func mustKnock(t *testing.T, env *knockEnv, username, sessionID string) {
+	t.Helper()
+
+	headers := map[string]string{
+		"Origin":                   "http://kseli.app",
+		"Authorization":            env.inviteToken,
+		"X-Participant-Session-Id": sessionID,
+	}
+	body, _ := json.Marshal(chat.JoinRoomRequest{
+		Username: username,
+	})
+
+	status, respBody := sendRequest(env.mux, http.MethodPost, "/api/rooms/join", bytes.NewReader(body), headers)
+	if status != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d, body: %s", status, string(respBody))
+	}
+
+	var resp chat.JoinRoomResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		t.Fatalf("failed to unmarshal pending resp: %v", err)
+	}
+	if !resp.Pending || resp.Token != "" {
+		t.Fatalf("expected a pending join without a token, got %+v", resp)
+	}
+}
+
+func mustGetKnock(t *testing.T, env *knockEnv) chat.Knock {
+	t.Helper()
+
+	_, resp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if len(resp.Knocks) != 1 {
+		t.Fatalf("expected 1 pending knock, got %d", len(resp.Knocks))
+	}
+
+	return resp.Knocks[0]
+}
+
+func Test_Knock_Approve(t *testing.T) {
+	env := newKnockEnv(t)
+
+	mustKnock(t, env, "user", "user")
+
+	// asking again while pending must not create a second knock
+	mustKnock(t, env, "user", "user")
+	knock := mustGetKnock(t, env)
+	if knock.Username != "user" {
+		t.Fatalf("expected knock from %q, got %q", "user", knock.Username)
+	}
+
+	answerKnock(t, true, 0, env.mux, knock.ID, "approve", env.roomID, "http://kseli.app", env.adminToken)
+
+	joinResp, _ := joinRoom(t, true, 0, env.mux, "user", "http://kseli.app", env.inviteToken, "user")
+	if joinResp.Token == "" {
+		t.Fatal("expected a token after the knock was approved")
+	}
+
+	_, resp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if len(resp.Participants) != 2 {
+		t.Fatalf("expected 2 participants, got %d", len(resp.Participants))
+	}
+	if len(resp.Knocks) != 0 {
+		t.Fatalf("expected no pending knocks, got %d", len(resp.Knocks))
+	}
+}
+
+func Test_Knock_Deny(t *testing.T) {
+	env := newKnockEnv(t)
+
+	mustKnock(t, env, "user", "user")
+	knock := mustGetKnock(t, env)
+
+	answerKnock(t, true, 0, env.mux, knock.ID, "deny", env.roomID, "http://kseli.app", env.adminToken)
+
+	_, errResp := joinRoom(t, false, http.StatusForbidden, env.mux, "user", "http://kseli.app", env.inviteToken, "user")
+
+	expectedErrMsg := "Your request to join was denied."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_Knock_NotFound(t *testing.T) {
+	env := newKnockEnv(t)
+
+	errResp := answerKnock(t, false, http.StatusNotFound, env.mux, "missing", "approve", env.roomID, "http://kseli.app", env.adminToken)
+
+	expectedErrMsg := "Join request not found."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_Knock_NoPermission(t *testing.T) {
+	env := newKnockEnv(t)
+
+	mustKnock(t, env, "user", "user")
+	knock := mustGetKnock(t, env)
+	answerKnock(t, true, 0, env.mux, knock.ID, "approve", env.roomID, "http://kseli.app", env.adminToken)
+	joinResp, _ := joinRoom(t, true, 0, env.mux, "user", "http://kseli.app", env.inviteToken, "user")
+
+	mustKnock(t, env, "other", "other")
+	knock = mustGetKnock(t, env)
+
+	errResp := answerKnock(t, false, http.StatusForbidden, env.mux, knock.ID, "approve", env.roomID, "http://kseli.app", joinResp.Token)
+
+	expectedErrMsg := "You don't have permission to answer join requests."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_Knock_WSCommand(t *testing.T) {
+	env := newKnockEnv(t)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	conn := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.adminToken)
+
+	// drain own join message
+	mustReadWSJoin(t, conn)
+
+	mustKnock(t, env, "user", "user")
+
+	var knock chat.Knock
+	mustReadWSType(t, conn, "knock", &knock)
+	if knock.Username != "user" || knock.ID == "" {
+		t.Fatalf("unexpected knock event %+v", knock)
+	}
+
+	if err := wsutil.WriteClientText(conn, []byte(`{"type":"knock-approve","data":{"id":"`+knock.ID+`"}}`)); err != nil {
+		t.Fatalf("admin failed to send command: %v", err)
+	}
+
+	var answer chat.KnockAnswerMsg
+	mustReadWSType(t, conn, "knock-answered", &answer)
+	if answer.ID != knock.ID || !answer.Approved {
+		t.Fatalf("expected knock %q to be approved, got %+v", knock.ID, answer)
+	}
+
+	joinRoom(t, true, 0, env.mux, "user", "http://kseli.app", env.inviteToken, "user")
+
+	_, resp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if len(resp.Participants) != 2 {
+		t.Fatalf("expected 2 participants, got %d", len(resp.Participants))
+	}
+}
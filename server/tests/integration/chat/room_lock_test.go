@@ -0,0 +1,118 @@
+package chat_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kseli/config"
+	"kseli/features/chat"
+	"kseli/router"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+type lockEnv struct {
+	roomID       string
+	adminToken   string
+	regularToken string
+	inviteToken  string
+	mux          *http.ServeMux
+}
+
+func newLockEnv(t *testing.T) *lockEnv {
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	// 1) Create the room to get the admin token
+	createResp, _ := createRoom(t, true, 0, mux, 3, "admin", "http://kseli.app", config.APIKey, "admin")
+
+	// 2) Fetch invite token via get room as an admin
+	inviteToken, _, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+
+	// 3) Join the room to get the regular token
+	joinResp, _ := joinRoom(t, true, 0, mux, "user", "http://kseli.app", inviteToken, "user")
+
+	return &lockEnv{
+		roomID:       createResp.RoomID,
+		adminToken:   createResp.Token,
+		regularToken: joinResp.Token,
+		inviteToken:  inviteToken,
+		mux:          mux,
+	}
+}
+
+func Test_LockRoom_Success(t *testing.T) {
+	env := newLockEnv(t)
+
+	lockRoom(t, true, 0, env.mux, http.MethodPost, env.roomID, "http://kseli.app", env.adminToken)
+
+	_, resp, _ := getRoom(t, true, false, 0, env.mux, env.roomID, "http://kseli.app", env.regularToken)
+	if !resp.Locked {
+		t.Fatal("expected the room to be locked")
+	}
+
+	_, errResp := joinRoom(t, false, http.StatusLocked, env.mux, "other", "http://kseli.app", env.inviteToken, "other")
+
+	expectedErrMsg := "Chat Room is locked."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+
+	lockRoom(t, true, 0, env.mux, http.MethodDelete, env.roomID, "http://kseli.app", env.adminToken)
+
+	joinRoom(t, true, 0, env.mux, "other", "http://kseli.app", env.inviteToken, "other")
+}
+
+func Test_LockRoom_NotAdmin(t *testing.T) {
+	env := newLockEnv(t)
+
+	errResp := lockRoom(t, false, http.StatusForbidden, env.mux, http.MethodPost, env.roomID, "http://kseli.app", env.regularToken)
+
+	expectedErrMsg := "You don't have permission to lock this room."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_LockRoom_WSCommand(t *testing.T) {
+	env := newLockEnv(t)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	// drain join messages, the admin join is read before the user connects so only the admin gets it
+	conn1 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	conn2 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.regularToken)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+
+	// a member can't lock the room
+	if err := wsutil.WriteClientText(conn2, []byte(`{"type":"lock","data":{"locked":true}}`)); err != nil {
+		t.Fatalf("user failed to send command: %v", err)
+	}
+
+	var errMsg chat.ErrorMsg
+	mustReadWSType(t, conn2, "error", &errMsg)
+	if errMsg.Message != "You don't have permission to lock this room." {
+		t.Fatalf("unexpected error message %q", errMsg.Message)
+	}
+
+	if err := wsutil.WriteClientText(conn1, []byte(`{"type":"lock","data":{"locked":true}}`)); err != nil {
+		t.Fatalf("admin failed to send command: %v", err)
+	}
+
+	var lock chat.LockMsg
+	mustReadWSType(t, conn1, "lock-changed", &lock)
+	if !lock.Locked {
+		t.Fatal("expected admin to be notified of the lock")
+	}
+
+	mustReadWSType(t, conn2, "lock-changed", &lock)
+	if !lock.Locked {
+		t.Fatal("expected user to be notified of the lock")
+	}
+}
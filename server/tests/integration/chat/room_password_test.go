@@ -0,0 +1,226 @@
+package chat_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kseli/config"
+	"kseli/features/chat"
+	"kseli/router"
+)
+
+type passwordEnv struct {
+	roomID      string
+	adminToken  string
+	inviteToken string
+	mux         *http.ServeMux
+}
+
+func newPasswordEnv(t *testing.T, password string) *passwordEnv {
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	// 1) Create the room with a password to get the admin token
+	headers := map[string]string{
+		"Origin":                   "http://kseli.app",
+		"X-Api-Key":                config.APIKey,
+		"X-Participant-Session-Id": "admin",
+	}
+	body, _ := json.Marshal(chat.CreateRoomRequest{
+		Username:        "admin",
+		MaxParticipants: 3,
+		Password:        password,
+	})
+
+	status, respBody := sendRequest(mux, http.MethodPost, "/api/rooms", bytes.NewReader(body), headers)
+	if status != http.StatusCreated {
+		t.Fatalf("expected 201, got %d, body: %s", status, string(respBody))
+	}
+
+	var createResp chat.CreateRoomResponse
+	if err := json.Unmarshal(respBody, &createResp); err != nil {
+		t.Fatalf("failed to unmarshal success resp: %v", err)
+	}
+
+	// 2) Fetch invite token via get room as an admin
+	inviteToken, _, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+
+	return &passwordEnv{
+		roomID:      createResp.RoomID,
+		adminToken:  createResp.Token,
+		inviteToken: inviteToken,
+		mux:         mux,
+	}
+}
+
+func Test_RoomPassword_Success(t *testing.T) {
+	env := newPasswordEnv(t, "open sesame")
+
+	_, resp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if !resp.HasPassword {
+		t.Fatal("expected the room to report a password")
+	}
+
+	joinRoomWithPassword(t, true, 0, env.mux, "user", "open sesame", "http://kseli.app", env.inviteToken, "user")
+}
+
+func Test_RoomPassword_Wrong(t *testing.T) {
+	env := newPasswordEnv(t, "open sesame")
+
+	type testCase struct {
+		name           string
+		password       string
+		expectedStatus int
+		expectedErrMsg string
+	}
+
+	tests := []testCase{
+		{
+			name:           "Password missing",
+			password:       "",
+			expectedStatus: http.StatusUnauthorized,
+			expectedErrMsg: "This room requires a password.",
+		},
+		{
+			name:           "Password incorrect",
+			password:       "open sesame!",
+			expectedStatus: http.StatusForbidden,
+			expectedErrMsg: "Incorrect room password.",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, errResp := joinRoomWithPassword(t, false, tc.expectedStatus, env.mux, "user", tc.password, "http://kseli.app", env.inviteToken, "user")
+
+			if errResp.Message != tc.expectedErrMsg {
+				t.Fatalf("[%s] expected error message %q, got %q", tc.name, tc.expectedErrMsg, errResp.Message)
+			}
+		})
+	}
+}
+
+func Test_RoomPassword_Throttled(t *testing.T) {
+	defaultAttempts := config.MaxPasswordAttempts
+	config.MaxPasswordAttempts = 2
+	defer func() { config.MaxPasswordAttempts = defaultAttempts }()
+
+	env := newPasswordEnv(t, "open sesame")
+
+	joinRoomWithPassword(t, false, http.StatusForbidden, env.mux, "user", "wrong", "http://kseli.app", env.inviteToken, "user")
+	joinRoomWithPassword(t, false, http.StatusForbidden, env.mux, "user", "wrong", "http://kseli.app", env.inviteToken, "user")
+
+	// even the right password is refused while the room is blocked
+	headers := map[string]string{
+		"Origin":                   "http://kseli.app",
+		"Authorization":            env.inviteToken,
+		"X-Participant-Session-Id": "user",
+	}
+	body, _ := json.Marshal(chat.JoinRoomRequest{
+		Username: "user",
+		Password: "open sesame",
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/rooms/join", bytes.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	env.mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d, body: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header")
+	}
+}
+
+func Test_RoomPassword_SetAndRemove(t *testing.T) {
+	env := newPasswordEnv(t, "")
+
+	setRoomPassword(t, true, 0, env.mux, "new secret", env.roomID, "http://kseli.app", env.adminToken)
+
+	joinRoomWithPassword(t, false, http.StatusUnauthorized, env.mux, "user", "", "http://kseli.app", env.inviteToken, "user")
+	joinResp, _ := joinRoomWithPassword(t, true, 0, env.mux, "user", "new secret", "http://kseli.app", env.inviteToken, "user")
+
+	// only the admin can change the password
+	errResp := setRoomPassword(t, false, http.StatusForbidden, env.mux, "", env.roomID, "http://kseli.app", joinResp.Token)
+
+	expectedErrMsg := "You don't have permission to change the room password."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+
+	setRoomPassword(t, true, 0, env.mux, "", env.roomID, "http://kseli.app", env.adminToken)
+	joinRoom(t, true, 0, env.mux, "other", "http://kseli.app", env.inviteToken, "other")
+}
+
+func Test_RoomPassword_MultiByte(t *testing.T) {
+	env := newPasswordEnv(t, "")
+
+	// 64 runes is the longest allowed password, the limit is in characters not bytes.
+	// A 143 byte body for the 2 byte runes and a 271 byte one for the 4 byte runes.
+	cyrillic := strings.Repeat("ж", 64)
+	setRoomPassword(t, true, 0, env.mux, cyrillic, env.roomID, "http://kseli.app", env.adminToken)
+	joinRoomWithPassword(t, true, 0, env.mux, "user", cyrillic, "http://kseli.app", env.inviteToken, "user")
+
+	setRoomPassword(t, true, 0, env.mux, strings.Repeat("🔑", 64), env.roomID, "http://kseli.app", env.adminToken)
+}
+
+func Test_RoomPassword_Validation(t *testing.T) {
+	env := newPasswordEnv(t, "")
+
+	errResp := setRoomPassword(t, false, http.StatusBadRequest, env.mux, "abc", env.roomID, "http://kseli.app", env.adminToken)
+
+	expectedErrMsg := "Password must be between 4 and 64 characters."
+	if errResp.FieldErrors["password"] != expectedErrMsg {
+		t.Fatalf("expected password field error %q, got %q", expectedErrMsg, errResp.FieldErrors["password"])
+	}
+}
+
+func Test_RoomPassword_NotResetByCorrectPassword(t *testing.T) {
+	defaultAttempts := config.MaxPasswordAttempts
+	config.MaxPasswordAttempts = 2
+	defer func() { config.MaxPasswordAttempts = defaultAttempts }()
+
+	env := newPasswordEnv(t, "open sesame")
+
+	// a legitimate join in between doesn't give the guesses a fresh start
+	joinRoomWithPassword(t, false, http.StatusForbidden, env.mux, "user1", "wrong", "http://kseli.app", env.inviteToken, "user1")
+	joinRoomWithPassword(t, true, 0, env.mux, "user1", "open sesame", "http://kseli.app", env.inviteToken, "user1")
+	joinRoomWithPassword(t, false, http.StatusForbidden, env.mux, "user2", "wrong", "http://kseli.app", env.inviteToken, "user2")
+
+	_, errResp := joinRoomWithPassword(t, false, http.StatusTooManyRequests, env.mux, "user2", "open sesame", "http://kseli.app", env.inviteToken, "user2")
+	if !strings.HasPrefix(errResp.Message, "Too many wrong password attempts") {
+		t.Fatalf("expected the room to be blocked, got %q", errResp.Message)
+	}
+}
+
+func Test_RoomPassword_RepeatedKnock(t *testing.T) {
+	env := newKnockEnv(t)
+
+	setRoomPassword(t, true, 0, env.mux, "open sesame", env.roomID, "http://kseli.app", env.adminToken)
+
+	headers := map[string]string{
+		"Origin":                   "http://kseli.app",
+		"Authorization":            env.inviteToken,
+		"X-Participant-Session-Id": "user",
+	}
+	body, _ := json.Marshal(chat.JoinRoomRequest{
+		Username: "user",
+		Password: "open sesame",
+	})
+
+	status, respBody := sendRequest(env.mux, http.MethodPost, "/api/rooms/join", bytes.NewReader(body), headers)
+	if status != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d, body: %s", status, string(respBody))
+	}
+
+	// the knock got past the password already, repeating the join request doesn't ask for it again
+	mustKnock(t, env, "user", "user")
+}
@@ -0,0 +1,186 @@
+package chat_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kseli/config"
+	"kseli/features/chat"
+	"kseli/router"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+type rateLimitEnv struct {
+	roomID       string
+	adminToken   string
+	regularToken string
+	mux          *http.ServeMux
+}
+
+func newRateLimitEnv(t *testing.T) *rateLimitEnv {
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	// 1) Create the room to get the admin token
+	createResp, _ := createRoom(t, true, 0, mux, 2, "admin", "http://kseli.app", config.APIKey, "admin")
+
+	// 2) Fetch invite token via get room as an admin
+	inviteToken, _, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+
+	// 3) Join the room to get the regular token
+	joinResp, _ := joinRoom(t, true, 0, mux, "user", "http://kseli.app", inviteToken, "user")
+
+	return &rateLimitEnv{
+		roomID:       createResp.RoomID,
+		adminToken:   createResp.Token,
+		regularToken: joinResp.Token,
+		mux:          mux,
+	}
+}
+
+// dialRateLimitEnv connects both participants and drains the join messages
+func dialRateLimitEnv(t *testing.T, env *rateLimitEnv, serverAddr string) (net.Conn, net.Conn) {
+	conn1 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	conn2 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.regularToken)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+
+	return conn1, conn2
+}
+
+func Test_RateLimit_Burst(t *testing.T) {
+	defaultBurst, defaultRefill := config.MessageBurst, config.MessageRefill
+	config.MessageBurst, config.MessageRefill = 2, time.Minute
+	defer func() { config.MessageBurst, config.MessageRefill = defaultBurst, defaultRefill }()
+
+	env := newRateLimitEnv(t)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+
+	conn1, conn2 := dialRateLimitEnv(t, env, server.Listener.Addr().String())
+
+	for _, content := range []string{"one", "two", "three"} {
+		if err := wsutil.WriteClientText(conn2, []byte(content)); err != nil {
+			t.Fatalf("user failed to send message: %v", err)
+		}
+	}
+
+	assertChatMsg(t, mustReadWSChat(t, conn2), "user", "one")
+	assertChatMsg(t, mustReadWSChat(t, conn2), "user", "two")
+
+	var limited chat.RateLimitMsg
+	mustReadWSType(t, conn2, "rate-limited", &limited)
+	if limited.RetryAfterMs <= 0 {
+		t.Fatalf("expected a positive retry delay, got %d", limited.RetryAfterMs)
+	}
+
+	// the third message was never relayed, the admin gets the next one they send themselves
+	assertChatMsg(t, mustReadWSChat(t, conn1), "user", "one")
+	assertChatMsg(t, mustReadWSChat(t, conn1), "user", "two")
+
+	if err := wsutil.WriteClientText(conn1, []byte("calm down")); err != nil {
+		t.Fatalf("admin failed to send message: %v", err)
+	}
+	assertChatMsg(t, mustReadWSChat(t, conn1), "admin", "calm down")
+}
+
+func Test_RateLimit_MsgCommand(t *testing.T) {
+	defaultBurst, defaultRefill := config.MessageBurst, config.MessageRefill
+	config.MessageBurst, config.MessageRefill = 1, time.Minute
+	defer func() { config.MessageBurst, config.MessageRefill = defaultBurst, defaultRefill }()
+
+	env := newRateLimitEnv(t)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+
+	conn1, conn2 := dialRateLimitEnv(t, env, server.Listener.Addr().String())
+
+	mustSendWSText(t, conn2, "one")
+	assertChatMsg(t, mustReadWSChat(t, conn2), "user", "one")
+
+	// a client using the command envelope gets the reason in the reply to the command, without a separate notice
+	mustSendWSText(t, conn2, `{"type":"msg","id":2,"data":{"content":"two"}}`)
+
+	var errMsg chat.ErrorMsg
+	mustReadWSType(t, conn2, "error", &errMsg)
+	if errMsg.ID != 2 || !strings.HasPrefix(errMsg.Message, "You are sending messages too fast, try again in") {
+		t.Fatalf("expected a rate limit error for command 2, got %+v", errMsg)
+	}
+
+	mustSendWSText(t, conn1, "calm down")
+	assertChatMsg(t, mustReadWSChat(t, conn2), "admin", "calm down")
+}
+
+func Test_RateLimit_SlowMode(t *testing.T) {
+	env := newRateLimitEnv(t)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+
+	conn1, conn2 := dialRateLimitEnv(t, env, server.Listener.Addr().String())
+
+	setSlowMode(t, true, 0, env.mux, 30, env.roomID, "http://kseli.app", env.adminToken)
+
+	var slowMode chat.SlowModeMsg
+	mustReadWSType(t, conn1, "slow-mode-changed", &slowMode)
+	mustReadWSType(t, conn2, "slow-mode-changed", &slowMode)
+	if slowMode.Seconds != 30 {
+		t.Fatalf("expected slow mode of 30 seconds, got %d", slowMode.Seconds)
+	}
+
+	_, resp, _ := getRoom(t, true, false, 0, env.mux, env.roomID, "http://kseli.app", env.regularToken)
+	if resp.SlowModeSeconds != 30 {
+		t.Fatalf("expected room slow mode of 30 seconds, got %d", resp.SlowModeSeconds)
+	}
+
+	for _, content := range []string{"first", "second"} {
+		if err := wsutil.WriteClientText(conn2, []byte(content)); err != nil {
+			t.Fatalf("user failed to send message: %v", err)
+		}
+	}
+
+	assertChatMsg(t, mustReadWSChat(t, conn2), "user", "first")
+
+	var limited chat.RateLimitMsg
+	mustReadWSType(t, conn2, "rate-limited", &limited)
+	if limited.RetryAfterMs <= 0 || limited.RetryAfterMs > 30_000 {
+		t.Fatalf("expected a retry delay within the slow mode interval, got %d", limited.RetryAfterMs)
+	}
+
+	// the admin is not slowed down
+	for _, content := range []string{"a", "b"} {
+		if err := wsutil.WriteClientText(conn1, []byte(content)); err != nil {
+			t.Fatalf("admin failed to send message: %v", err)
+		}
+	}
+	assertChatMsg(t, mustReadWSChat(t, conn1), "user", "first")
+	assertChatMsg(t, mustReadWSChat(t, conn1), "admin", "a")
+	assertChatMsg(t, mustReadWSChat(t, conn1), "admin", "b")
+}
+
+func Test_RateLimit_SlowModeValidation(t *testing.T) {
+	env := newRateLimitEnv(t)
+
+	errResp := setSlowMode(t, false, http.StatusForbidden, env.mux, 10, env.roomID, "http://kseli.app", env.regularToken)
+
+	expectedErrMsg := "You don't have permission to change slow mode in this room."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+
+	errResp = setSlowMode(t, false, http.StatusBadRequest, env.mux, 301, env.roomID, "http://kseli.app", env.adminToken)
+
+	expectedErrMsg = "Slow mode must be between 0 and 300 seconds."
+	if errResp.FieldErrors["seconds"] != expectedErrMsg {
+		t.Fatalf("expected seconds field error %q, got %q", expectedErrMsg, errResp.FieldErrors["seconds"])
+	}
+}
@@ -0,0 +1,180 @@
+package chat_test
+
+import (
+	"net"
+	"testing"
+
+	"kseli/features/chat"
+)
+
+// newReceiptConns connects the admin and two users of a room for three over WS
+func newReceiptConns(t *testing.T) (conn1, conn2, conn3 net.Conn) {
+	env := newHistoryEnv(t, false)
+
+	join2, _ := joinRoom(t, true, 0, env.mux, "user2", "http://kseli.app", env.inviteToken, "user2")
+	join3, _ := joinRoom(t, true, 0, env.mux, "user3", "http://kseli.app", env.inviteToken, "user3")
+
+	conn1 = dialWS(t, "ws://"+env.serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	conn2 = dialWS(t, "ws://"+env.serverAddr+"/ws/room?token="+join2.Token)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+
+	conn3 = dialWS(t, "ws://"+env.serverAddr+"/ws/room?token="+join3.Token)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+	mustReadWSJoin(t, conn3)
+
+	return conn1, conn2, conn3
+}
+
+// mustReadReceiptsUntil reads receipt messages until one holds the wanted counts for the message,
+// the changes of several recipients can come in one message or spread over a few
+func mustReadReceiptsUntil(t *testing.T, conn net.Conn, wantSeq uint64, wantDelivered, wantRead int) chat.ReceiptMsg {
+	t.Helper()
+
+	for {
+		var msg chat.ReceiptsMsg
+		mustReadWSType(t, conn, "receipts", &msg)
+
+		for _, receipt := range msg.Receipts {
+			if receipt.Seq != wantSeq {
+				continue
+			}
+			if receipt.Delivered > wantDelivered || receipt.Read > wantRead {
+				t.Fatalf("expected receipt for %d with %d delivered and %d read, got %+v", wantSeq, wantDelivered, wantRead, receipt)
+			}
+			if receipt.Delivered == wantDelivered && receipt.Read == wantRead {
+				return receipt
+			}
+		}
+	}
+}
+
+func assertReceipt(t *testing.T, receipt chat.ReceiptMsg, wantSeq uint64, wantDelivered, wantRead int) {
+	t.Helper()
+	if receipt.Seq != wantSeq || receipt.Delivered != wantDelivered || receipt.Read != wantRead {
+		t.Fatalf("expected receipt for %d with %d delivered and %d read, got %+v", wantSeq, wantDelivered, wantRead, receipt)
+	}
+}
+
+func Test_Receipts_DeliveredAndRead(t *testing.T) {
+	conn1, conn2, conn3 := newReceiptConns(t)
+
+	// 1) The sender learns about the deliveries
+	mustSendWSText(t, conn1, `{"type":"msg","data":{"content":"hello"}}`)
+
+	sent := mustReadWSChat(t, conn1)
+	mustReadWSChat(t, conn2)
+	mustReadWSChat(t, conn3)
+
+	receipt := mustReadReceiptsUntil(t, conn1, 1, 2, 0)
+	if receipt.MsgID != sent.MsgID {
+		t.Fatalf("expected receipt for message %q, got %q", sent.MsgID, receipt.MsgID)
+	}
+
+	// 2) and about the reads
+	mustSendWSText(t, conn2, `{"type":"read","data":{"seq":1}}`)
+	mustSendWSText(t, conn3, `{"type":"read","data":{"seq":1}}`)
+	mustReadReceiptsUntil(t, conn1, 1, 2, 2)
+
+	// 3) Reading again or reading your own message changes nothing, the next message the sender gets is the chat message
+	mustSendWSText(t, conn2, `{"type":"read","data":{"seq":1}}`)
+	mustSendWSText(t, conn1, `{"type":"read","data":{"seq":1}}`)
+	mustSendWSText(t, conn3, "done")
+
+	assertChatMsg(t, mustReadWSChat(t, conn1), "user3", "done")
+}
+
+func Test_Receipts_ReadUpTo(t *testing.T) {
+	conn1, conn2, conn3 := newReceiptConns(t)
+
+	// the sender gets their own messages back right away, before the first receipts are flushed
+	for _, content := range []string{"one", "two", "three"} {
+		mustSendWSText(t, conn1, `{"type":"msg","data":{"content":"`+content+`"}}`)
+	}
+	for range 3 {
+		mustReadWSChat(t, conn1)
+		mustReadWSChat(t, conn2)
+		mustReadWSChat(t, conn3)
+	}
+
+	// every recipient writes the messages in order, once the last one is reported the others are too
+	mustReadReceiptsUntil(t, conn1, 3, 2, 0)
+
+	// one read covers every message up to the sequence number, the sender gets a single message for all of them
+	mustSendWSText(t, conn2, `{"type":"read","data":{"seq":3}}`)
+
+	var msg chat.ReceiptsMsg
+	mustReadWSType(t, conn1, "receipts", &msg)
+	if len(msg.Receipts) != 3 {
+		t.Fatalf("expected receipts for 3 messages, got %+v", msg.Receipts)
+	}
+	for i, receipt := range msg.Receipts {
+		assertReceipt(t, receipt, uint64(i+1), 2, 1)
+	}
+}
+
+func Test_Receipts_LateJoinerRead(t *testing.T) {
+	env := newHistoryEnv(t, false)
+
+	join2, _ := joinRoom(t, true, 0, env.mux, "user2", "http://kseli.app", env.inviteToken, "user2")
+
+	conn1 := dialWS(t, "ws://"+env.serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	conn2 := dialWS(t, "ws://"+env.serverAddr+"/ws/room?token="+join2.Token)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+
+	// 1) The message is sent before user3 joins
+	mustSendWSText(t, conn1, `{"type":"msg","data":{"content":"hello"}}`)
+	mustReadWSChat(t, conn1)
+	mustReadWSChat(t, conn2)
+	mustReadReceiptsUntil(t, conn1, 1, 1, 0)
+
+	join3, _ := joinRoom(t, true, 0, env.mux, "user3", "http://kseli.app", env.inviteToken, "user3")
+	conn3 := dialWS(t, "ws://"+env.serverAddr+"/ws/room?token="+join3.Token)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+	mustReadWSJoin(t, conn3)
+
+	// 2) user3 never got the message, their read is not counted
+	mustSendWSText(t, conn3, `{"type":"read","id":1,"data":{"seq":1}}`)
+
+	var ack chat.AckMsg
+	mustReadWSType(t, conn3, "ack", &ack)
+
+	mustSendWSText(t, conn2, `{"type":"read","data":{"seq":1}}`)
+	mustReadReceiptsUntil(t, conn1, 1, 1, 1)
+}
+
+func Test_Receipts_PlainTextSenders(t *testing.T) {
+	conn1, conn2, conn3 := newReceiptConns(t)
+
+	// clients that send plain text don't know about receipts and get none
+	mustSendWSText(t, conn1, "hello")
+	mustReadWSChat(t, conn1)
+	mustReadWSChat(t, conn2)
+	mustReadWSChat(t, conn3)
+
+	mustSendWSText(t, conn2, `{"type":"read","data":{"seq":1}}`)
+	mustSendWSText(t, conn3, "done")
+
+	assertChatMsg(t, mustReadWSChat(t, conn1), "user3", "done")
+}
+
+func Test_Receipts_InvalidRead(t *testing.T) {
+	_, conn2, _ := newReceiptConns(t)
+
+	mustSendWSText(t, conn2, `{"type":"read","id":1,"data":{}}`)
+
+	var errMsg chat.ErrorMsg
+	mustReadWSType(t, conn2, "error", &errMsg)
+
+	expectedErrMsg := "Sequence number is required in the command."
+	if errMsg.ID != 1 || errMsg.Message != expectedErrMsg {
+		t.Fatalf("expected error %q for command 1, got %+v", expectedErrMsg, errMsg)
+	}
+}
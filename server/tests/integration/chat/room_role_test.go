@@ -0,0 +1,164 @@
+package chat_test
+
+import (
+	"net/http"
+	"testing"
+
+	"kseli/common"
+	"kseli/config"
+	"kseli/router"
+)
+
+type roleEnv struct {
+	roomID     string
+	adminToken string
+	user1Token string
+	user2Token string
+	mux        *http.ServeMux
+}
+
+func newRoleEnv(t *testing.T) *roleEnv {
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	// 1) Create the room to get the admin token
+	createResp, _ := createRoom(t, true, 0, mux, 3, "admin", "http://kseli.app", config.APIKey, "admin")
+
+	// 2) Fetch invite token via get room as an admin
+	inviteToken, _, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+
+	// 3) Join the room with two regular users
+	joinResp1, _ := joinRoom(t, true, 0, mux, "user1", "http://kseli.app", inviteToken, "user1")
+	joinResp2, _ := joinRoom(t, true, 0, mux, "user2", "http://kseli.app", inviteToken, "user2")
+
+	return &roleEnv{
+		roomID:     createResp.RoomID,
+		adminToken: createResp.Token,
+		user1Token: joinResp1.Token,
+		user2Token: joinResp2.Token,
+		mux:        mux,
+	}
+}
+
+func Test_SetRole_PromoteToModerator(t *testing.T) {
+	env := newRoleEnv(t)
+
+	setRole(t, true, 0, env.mux, 2, common.Moderator, env.roomID, "http://kseli.app", env.adminToken)
+
+	// moderators see the invite link
+	_, resp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.user1Token)
+	if resp.UserRole != common.Moderator {
+		t.Fatalf("expected UserRole %v, got %v", common.Moderator, resp.UserRole)
+	}
+
+	// moderators can't close or extend the room
+	errResp := deleteRoom(t, false, http.StatusForbidden, env.mux, env.roomID, "http://kseli.app", env.user1Token)
+	expectedErrMsg := "You don't have permission to close this room."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+
+	// moderators can't act on the admin
+	errResp = kickOrBanUser(t, false, http.StatusForbidden, env.mux, 1, "kick", env.roomID, "http://kseli.app", env.user1Token)
+	expectedErrMsg = "You can't kick a participant with the same or a higher role."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+
+	// moderators can kick members
+	kickOrBanUser(t, true, 0, env.mux, 3, "kick", env.roomID, "http://kseli.app", env.user1Token)
+}
+
+func Test_SetRole_Demote(t *testing.T) {
+	env := newRoleEnv(t)
+
+	setRole(t, true, 0, env.mux, 2, common.Moderator, env.roomID, "http://kseli.app", env.adminToken)
+	setRole(t, true, 0, env.mux, 2, common.Member, env.roomID, "http://kseli.app", env.adminToken)
+
+	_, resp, _ := getRoom(t, true, false, 0, env.mux, env.roomID, "http://kseli.app", env.user1Token)
+	if resp.UserRole != common.Member {
+		t.Fatalf("expected UserRole %v, got %v", common.Member, resp.UserRole)
+	}
+
+	errResp := kickOrBanUser(t, false, http.StatusForbidden, env.mux, 3, "ban", env.roomID, "http://kseli.app", env.user1Token)
+	expectedErrMsg := "You don't have permission to ban anyone from this room."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_SetRole_NoPermission(t *testing.T) {
+	env := newRoleEnv(t)
+
+	setRole(t, true, 0, env.mux, 2, common.Moderator, env.roomID, "http://kseli.app", env.adminToken)
+
+	// neither members nor moderators can change roles
+	for _, token := range []string{env.user1Token, env.user2Token} {
+		errResp := setRole(t, false, http.StatusForbidden, env.mux, 3, common.Moderator, env.roomID, "http://kseli.app", token)
+
+		expectedErrMsg := "You don't have permission to change roles in this room."
+		if errResp.Message != expectedErrMsg {
+			t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+		}
+	}
+}
+
+func Test_SetRole_BadRequests(t *testing.T) {
+	env := newRoleEnv(t)
+
+	type testCase struct {
+		name           string
+		userID         uint32
+		role           common.Role
+		expectedStatus int
+		expectedErrMsg string
+	}
+
+	tests := []testCase{
+		{
+			name:           "userId empty",
+			userID:         0,
+			role:           common.Moderator,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: "User Id is required in the request.",
+		},
+		{
+			name:           "promote to admin",
+			userID:         2,
+			role:           common.Admin,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: "Role must be either member or moderator.",
+		},
+		{
+			name:           "unknown role",
+			userID:         2,
+			role:           9,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: "Role must be either member or moderator.",
+		},
+		{
+			name:           "own role",
+			userID:         1,
+			role:           common.Member,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: "You can't change your own role.",
+		},
+		{
+			name:           "user not found",
+			userID:         4,
+			role:           common.Moderator,
+			expectedStatus: http.StatusNotFound,
+			expectedErrMsg: "Participant with ID '4' not found in room",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			errResp := setRole(t, false, tc.expectedStatus, env.mux, tc.userID, tc.role, env.roomID, "http://kseli.app", env.adminToken)
+
+			if errResp.Message != tc.expectedErrMsg {
+				t.Fatalf("[%s] expected error message %q, got %q", tc.name, tc.expectedErrMsg, errResp.Message)
+			}
+		})
+	}
+}
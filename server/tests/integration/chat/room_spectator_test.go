@@ -0,0 +1,136 @@
+package chat_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kseli/common"
+	"kseli/config"
+	"kseli/features/chat"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+type spectatorEnv struct {
+	*joinEnv
+	memberToken    string
+	spectatorToken string
+	// invite token of the spectator invite
+	spectatorInvite string
+}
+
+// newSpectatorEnv fills up a room for two and lets a spectator in on top
+func newSpectatorEnv(t *testing.T) *spectatorEnv {
+	env := newJoinEnv(t)
+
+	memberResp, _ := joinRoom(t, true, 0, env.mux, "member", "http://kseli.app", env.invitetoken, "member")
+
+	_, spectatorInvite, _ := createInvite(t, true, 0, env.mux, chat.CreateInviteRequest{
+		Label:     "observers",
+		Spectator: true,
+	}, env.roomID, "http://kseli.app", env.adminToken)
+
+	spectatorResp, _ := joinRoom(t, true, 0, env.mux, "spectator", "http://kseli.app", spectatorInvite, "spectator")
+
+	return &spectatorEnv{
+		joinEnv:         env,
+		memberToken:     memberResp.Token,
+		spectatorToken:  spectatorResp.Token,
+		spectatorInvite: spectatorInvite,
+	}
+}
+
+func Test_Spectator_Join(t *testing.T) {
+	env := newSpectatorEnv(t)
+
+	// 1) The spectator doesn't take up a participant slot, members still can't join
+	_, errResp := joinRoom(t, false, http.StatusConflict, env.mux, "user", "http://kseli.app", env.invitetoken, "user")
+	if errResp.Message != "Chat Room is full." {
+		t.Fatalf("expected error message %q, got %q", "Chat Room is full.", errResp.Message)
+	}
+
+	// 2) Spectators are listed separately
+	_, getResp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if len(getResp.Participants) != 2 {
+		t.Fatalf("expected 2 participants, got %d", len(getResp.Participants))
+	}
+	if len(getResp.Spectators) != 1 || getResp.Spectators[0].Username != "spectator" || getResp.Spectators[0].Role != common.Spectator {
+		t.Fatalf("expected the spectator to be listed separately, got %+v", getResp.Spectators)
+	}
+
+	// 3) The spectator can see the room too
+	_, getResp, _ = getRoom(t, true, false, 0, env.mux, env.roomID, "http://kseli.app", env.spectatorToken)
+	if getResp.UserRole != common.Spectator {
+		t.Fatalf("expected role %d, got %d", common.Spectator, getResp.UserRole)
+	}
+}
+
+func Test_Spectator_Limit(t *testing.T) {
+	defaultMax := config.MaxRoomSpectators
+	config.MaxRoomSpectators = 1
+	defer func() { config.MaxRoomSpectators = defaultMax }()
+
+	env := newSpectatorEnv(t)
+
+	_, errResp := joinRoom(t, false, http.StatusConflict, env.mux, "spectator2", "http://kseli.app", env.spectatorInvite, "spectator2")
+
+	expectedErrMsg := "Chat Room can't take any more spectators."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+
+	// a spectator leaving frees a spectator slot
+	kickOrBanUser(t, true, 0, env.mux, 3, "kick", env.roomID, "http://kseli.app", env.adminToken)
+	joinRoom(t, true, 0, env.mux, "spectator2", "http://kseli.app", env.spectatorInvite, "spectator2")
+}
+
+func Test_Spectator_CantPost(t *testing.T) {
+	env := newSpectatorEnv(t)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	conn1 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	conn2 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.spectatorToken)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+
+	// 1) The spectator's message is rejected
+	if err := wsutil.WriteClientText(conn2, []byte("hello?")); err != nil {
+		t.Fatalf("spectator failed to send message: %v", err)
+	}
+
+	var errMsg chat.ErrorMsg
+	mustReadWSType(t, conn2, "error", &errMsg)
+	if errMsg.Message != "Spectators can't send messages." {
+		t.Fatalf("expected error message %q, got %q", "Spectators can't send messages.", errMsg.Message)
+	}
+
+	// 2) The spectator still gets the chat, and the admin never got the rejected message
+	if err := wsutil.WriteClientText(conn1, []byte("welcome")); err != nil {
+		t.Fatalf("admin failed to send message: %v", err)
+	}
+
+	assertChatMsg(t, mustReadWSChat(t, conn1), "admin", "welcome")
+	assertChatMsg(t, mustReadWSChat(t, conn2), "admin", "welcome")
+}
+
+func Test_Spectator_NoOtherRole(t *testing.T) {
+	env := newSpectatorEnv(t)
+
+	expectedErrMsg := "Spectators can't be given another role."
+
+	errResp := setRole(t, false, http.StatusBadRequest, env.mux, 3, common.Moderator, env.roomID, "http://kseli.app", env.adminToken)
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+
+	_, errResp = transferAdmin(t, false, http.StatusBadRequest, env.mux, 3, env.roomID, "http://kseli.app", env.adminToken)
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
@@ -0,0 +1,217 @@
+package chat_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kseli/auth"
+	"kseli/common"
+	"kseli/config"
+	"kseli/features/chat"
+	"kseli/router"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+type transferEnv struct {
+	roomID       string
+	adminToken   string
+	regularToken string
+	mux          *http.ServeMux
+}
+
+func newTransferEnv(t *testing.T, autoPromote bool) *transferEnv {
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	// 1) Create the room to get the admin token
+	headers := map[string]string{
+		"Origin":                   "http://kseli.app",
+		"X-Api-Key":                config.APIKey,
+		"X-Participant-Session-Id": "admin",
+	}
+	body, _ := json.Marshal(chat.CreateRoomRequest{
+		Username:        "admin",
+		MaxParticipants: 3,
+		AutoPromote:     autoPromote,
+	})
+
+	status, respBody := sendRequest(mux, http.MethodPost, "/api/rooms", bytes.NewReader(body), headers)
+	if status != http.StatusCreated {
+		t.Fatalf("expected 201, got %d, body: %s", status, string(respBody))
+	}
+
+	var createResp chat.CreateRoomResponse
+	if err := json.Unmarshal(respBody, &createResp); err != nil {
+		t.Fatalf("failed to unmarshal success resp: %v", err)
+	}
+
+	// 2) Fetch invite token via get room as an admin
+	inviteToken, _, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+
+	// 3) Join the room to get the regular token
+	joinResp, _ := joinRoom(t, true, 0, mux, "user", "http://kseli.app", inviteToken, "user")
+
+	return &transferEnv{
+		roomID:       createResp.RoomID,
+		adminToken:   createResp.Token,
+		regularToken: joinResp.Token,
+		mux:          mux,
+	}
+}
+
+func Test_TransferAdmin_Success(t *testing.T) {
+	env := newTransferEnv(t, false)
+
+	resp, _ := transferAdmin(t, true, 0, env.mux, 2, env.roomID, "http://kseli.app", env.adminToken)
+
+	claims, err := auth.ValidateToken[auth.Claims](resp.Token)
+	if err != nil {
+		t.Fatalf("expected a valid reissued token, got error: %v", err)
+	}
+	if claims.Role != common.Member {
+		t.Fatalf("expected reissued token with role %v, got %v", common.Member, claims.Role)
+	}
+
+	// the old admin token must not grant admin rights anymore
+	_, getResp, _ := getRoom(t, true, false, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if getResp.UserRole != common.Member || getResp.InviteLink != "" {
+		t.Fatalf("expected former admin to be a member without invite link, got role %v, link %q", getResp.UserRole, getResp.InviteLink)
+	}
+
+	errResp := deleteRoom(t, false, http.StatusForbidden, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	expectedErrMsg := "You don't have permission to close this room."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+
+	// the new admin gets admin rights even with the token issued at join
+	_, getResp, _ = getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.regularToken)
+	if getResp.UserRole != common.Admin {
+		t.Fatalf("expected new admin role %v, got %v", common.Admin, getResp.UserRole)
+	}
+}
+
+func Test_TransferAdmin_NotAdmin(t *testing.T) {
+	env := newTransferEnv(t, false)
+
+	_, errResp := transferAdmin(t, false, http.StatusForbidden, env.mux, 1, env.roomID, "http://kseli.app", env.regularToken)
+
+	expectedErrMsg := "You don't have permission to transfer the admin role."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_TransferAdmin_ToSelf(t *testing.T) {
+	env := newTransferEnv(t, false)
+
+	_, errResp := transferAdmin(t, false, http.StatusBadRequest, env.mux, 1, env.roomID, "http://kseli.app", env.adminToken)
+
+	expectedErrMsg := "You are already the admin of the room."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_TransferAdmin_UserNotFound(t *testing.T) {
+	env := newTransferEnv(t, false)
+
+	_, errResp := transferAdmin(t, false, http.StatusNotFound, env.mux, 3, env.roomID, "http://kseli.app", env.adminToken)
+
+	expectedErrMsg := "Participant with ID '3' not found in room"
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_TransferAdmin_WSCommand(t *testing.T) {
+	env := newTransferEnv(t, false)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	// drain join messages, the admin join is read before the user connects so only the admin gets it
+	conn1 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	conn2 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.regularToken)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+
+	if err := wsutil.WriteClientText(conn1, []byte(`{"type":"transfer","data":{"userId":2}}`)); err != nil {
+		t.Fatalf("admin failed to send command: %v", err)
+	}
+
+	var token chat.TokenMsg
+	mustReadWSType(t, conn2, "token", &token)
+
+	claims, err := auth.ValidateToken[auth.Claims](token.Token)
+	if err != nil {
+		t.Fatalf("expected a valid reissued token, got error: %v", err)
+	}
+	if claims.Role != common.Admin {
+		t.Fatalf("expected reissued token with role %v, got %v", common.Admin, claims.Role)
+	}
+
+	var role chat.RoleMsg
+	mustReadWSType(t, conn2, "role-changed", &role)
+	if role.ID != 1 || role.Role != common.Member {
+		t.Fatalf("expected participant 1 to become a member, got %+v", role)
+	}
+
+	mustReadWSType(t, conn2, "role-changed", &role)
+	if role.ID != 2 || role.Role != common.Admin {
+		t.Fatalf("expected participant 2 to become the admin, got %+v", role)
+	}
+}
+
+func Test_TransferAdmin_AutoPromote(t *testing.T) {
+	env := newTransferEnv(t, true)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	// drain join messages, the admin join is read before the user connects so only the admin gets it
+	conn1 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	conn2 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.regularToken)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+
+	err := wsutil.WriteClientMessage(conn1, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "leave"))
+	if err != nil {
+		t.Fatalf("admin failed to send close: %v", err)
+	}
+
+	var token chat.TokenMsg
+	mustReadWSType(t, conn2, "token", &token)
+
+	claims, err := auth.ValidateToken[auth.Claims](token.Token)
+	if err != nil {
+		t.Fatalf("expected a valid reissued token, got error: %v", err)
+	}
+	if claims.Role != common.Admin {
+		t.Fatalf("expected reissued token with role %v, got %v", common.Admin, claims.Role)
+	}
+
+	assertLeaveMsg(t, mustReadWSLeave(t, conn2).ID, 1)
+
+	var role chat.RoleMsg
+	mustReadWSType(t, conn2, "role-changed", &role)
+	if role.ID != 2 || role.Role != common.Admin {
+		t.Fatalf("expected participant 2 to become the admin, got %+v", role)
+	}
+
+	_, getResp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", token.Token)
+	if len(getResp.Participants) != 1 {
+		t.Fatalf("expected 1 participant left in the room, got %d", len(getResp.Participants))
+	}
+}
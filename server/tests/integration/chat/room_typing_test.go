@@ -0,0 +1,166 @@
+package chat_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kseli/config"
+	"kseli/features/chat"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+func assertTypingMsg(t *testing.T, msg chat.TypingMsg, wantID uint32, wantTyping bool) {
+	t.Helper()
+	if msg.ID != wantID || msg.Typing != wantTyping {
+		t.Fatalf("expected typing %v for participant %d, got %+v", wantTyping, wantID, msg)
+	}
+}
+
+func Test_Typing_StartStop(t *testing.T) {
+	conn1, conn2 := newCommandConns(t)
+
+	// 1) The others see the participant typing, the participant doesn't get their own event
+	mustSendWSText(t, conn2, `{"type":"typing","data":{"typing":true}}`)
+
+	var typing chat.TypingMsg
+	mustReadWSType(t, conn1, "typing", &typing)
+	assertTypingMsg(t, typing, 2, true)
+
+	// 2) Refreshing the typing state isn't sent again, the stop is
+	mustSendWSText(t, conn2, `{"type":"typing","data":{"typing":true}}`)
+	mustSendWSText(t, conn2, `{"type":"typing","data":{"typing":false}}`)
+
+	mustReadWSType(t, conn1, "typing", &typing)
+	assertTypingMsg(t, typing, 2, false)
+
+	// 3) Typing never ends up in the chat, the next message for both is the chat message
+	mustSendWSText(t, conn2, "done")
+
+	assertChatMsg(t, mustReadWSChat(t, conn1), "user", "done")
+	assertChatMsg(t, mustReadWSChat(t, conn2), "user", "done")
+}
+
+func Test_Typing_RateLimited(t *testing.T) {
+	conn1, conn2 := newCommandConns(t)
+
+	mustSendWSText(t, conn2, `{"type":"typing","data":{"typing":true}}`)
+	mustSendWSText(t, conn2, `{"type":"typing","data":{"typing":false}}`)
+
+	var typing chat.TypingMsg
+	mustReadWSType(t, conn1, "typing", &typing)
+	mustReadWSType(t, conn1, "typing", &typing)
+
+	// starting again right away is refused
+	mustSendWSText(t, conn2, `{"type":"typing","id":4,"data":{"typing":true}}`)
+
+	var errMsg chat.ErrorMsg
+	mustReadWSType(t, conn2, "error", &errMsg)
+
+	expectedErrMsg := "Typing updates are sent too often."
+	if errMsg.ID != 4 || errMsg.Message != expectedErrMsg {
+		t.Fatalf("expected error %q for command 4, got %+v", expectedErrMsg, errMsg)
+	}
+}
+
+func Test_Typing_Timeout(t *testing.T) {
+	defaultTimeout := config.TypingTimeout
+	config.TypingTimeout = 100 * time.Millisecond
+	defer func() { config.TypingTimeout = defaultTimeout }()
+
+	conn1, conn2 := newCommandConns(t)
+
+	mustSendWSText(t, conn2, `{"type":"typing","data":{"typing":true}}`)
+
+	var typing chat.TypingMsg
+	mustReadWSType(t, conn1, "typing", &typing)
+	assertTypingMsg(t, typing, 2, true)
+
+	// the client stopped refreshing, the typing state is cleared
+	mustReadWSType(t, conn1, "typing", &typing)
+	assertTypingMsg(t, typing, 2, false)
+}
+
+func Test_Typing_ClearedOnLeave(t *testing.T) {
+	conn1, conn2 := newCommandConns(t)
+
+	mustSendWSText(t, conn2, `{"type":"typing","data":{"typing":true}}`)
+
+	var typing chat.TypingMsg
+	mustReadWSType(t, conn1, "typing", &typing)
+
+	if err := wsutil.WriteClientMessage(conn2, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "leave")); err != nil {
+		t.Fatalf("user failed to leave: %v", err)
+	}
+
+	mustReadWSType(t, conn1, "typing", &typing)
+	assertTypingMsg(t, typing, 2, false)
+	assertLeaveMsg(t, mustReadWSLeave(t, conn1).ID, 2)
+}
+
+func Test_Typing_Spectator(t *testing.T) {
+	env := newSpectatorEnv(t)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+
+	conn := dialWS(t, "ws://"+server.Listener.Addr().String()+"/ws/room?token="+env.spectatorToken)
+	mustReadWSJoin(t, conn)
+
+	mustSendWSText(t, conn, `{"type":"typing","data":{"typing":true}}`)
+
+	var errMsg chat.ErrorMsg
+	mustReadWSType(t, conn, "error", &errMsg)
+	if errMsg.Message != "Spectators can't send messages." {
+		t.Fatalf("expected error message %q, got %q", "Spectators can't send messages.", errMsg.Message)
+	}
+}
+
+func Test_Typing_Muted(t *testing.T) {
+	env := newGetEnv(t)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	conn1 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	conn2 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.regularToken)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+
+	mustSendWSText(t, conn2, `{"type":"typing","data":{"typing":true}}`)
+
+	var typing chat.TypingMsg
+	mustReadWSType(t, conn1, "typing", &typing)
+
+	// 1) Muting clears the typing state of the participant
+	kickOrBanUser(t, true, 0, env.mux, 2, "mute", env.roomID, "http://kseli.app", env.adminToken)
+
+	var mute chat.MuteMsg
+	mustReadWSType(t, conn1, "typing", &typing)
+	assertTypingMsg(t, typing, 2, false)
+	mustReadWSType(t, conn1, "muted", &mute)
+	mustReadWSType(t, conn2, "muted", &mute)
+
+	// 2) A muted participant can't start typing
+	mustSendWSText(t, conn2, `{"type":"typing","id":5,"data":{"typing":true}}`)
+
+	var errMsg chat.ErrorMsg
+	mustReadWSType(t, conn2, "error", &errMsg)
+
+	expectedErrMsg := "Muted participants can't send typing updates."
+	if errMsg.ID != 5 || errMsg.Message != expectedErrMsg {
+		t.Fatalf("expected error %q for command 5, got %+v", expectedErrMsg, errMsg)
+	}
+
+	// 3) The admin only gets the chat message sent after the unmute, no typing events in between
+	kickOrBanUser(t, true, 0, env.mux, 2, "unmute", env.roomID, "http://kseli.app", env.adminToken)
+	mustReadWSType(t, conn1, "unmuted", &mute)
+
+	mustSendWSText(t, conn2, "hello")
+	assertChatMsg(t, mustReadWSChat(t, conn1), "user", "hello")
+}
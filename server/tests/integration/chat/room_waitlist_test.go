@@ -0,0 +1,246 @@
+package chat_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"kseli/config"
+	"kseli/features/chat"
+	"kseli/router"
+)
+
+type waitlistEnv struct {
+	roomID      string
+	adminToken  string
+	inviteToken string
+	mux         *http.ServeMux
+}
+
+func newWaitlistEnv(t *testing.T) *waitlistEnv {
+	config.APIKey = "test-api-key"
+	mux := router.New()
+
+	// 1) Create a room for two with the waitlist enabled
+	headers := map[string]string{
+		"Origin":                   "http://kseli.app",
+		"X-Api-Key":                config.APIKey,
+		"X-Participant-Session-Id": "admin",
+	}
+	body, _ := json.Marshal(chat.CreateRoomRequest{
+		Username:        "admin",
+		MaxParticipants: 2,
+		Waitlist:        true,
+	})
+
+	status, respBody := sendRequest(mux, http.MethodPost, "/api/rooms", bytes.NewReader(body), headers)
+	if status != http.StatusCreated {
+		t.Fatalf("expected 201, got %d, body: %s", status, string(respBody))
+	}
+
+	var createResp chat.CreateRoomResponse
+	if err := json.Unmarshal(respBody, &createResp); err != nil {
+		t.Fatalf("failed to unmarshal success resp: %v", err)
+	}
+
+	// 2) Fetch invite token via get room as an admin and fill up the room
+	inviteToken, _, _ := getRoom(t, true, true, 0, mux, createResp.RoomID, "http://kseli.app", createResp.Token)
+	joinRoom(t, true, 0, mux, "user", "http://kseli.app", inviteToken, "user")
+
+	return &waitlistEnv{
+		roomID:      createResp.RoomID,
+		adminToken:  createResp.Token,
+		inviteToken: inviteToken,
+		mux:         mux,
+	}
+}
+
+// sendWaitlistJoin sends a join request and returns the status with the decoded success response
+func sendWaitlistJoin(t *testing.T, env *waitlistEnv, username, sessionID string) (int, chat.JoinRoomResponse) {
+	t.Helper()
+
+	headers := map[string]string{
+		"Origin":                   "http://kseli.app",
+		"Authorization":            env.inviteToken,
+		"X-Participant-Session-Id": sessionID,
+	}
+	body, _ := json.Marshal(chat.JoinRoomRequest{
+		Username: username,
+	})
+
+	status, respBody := sendRequest(env.mux, http.MethodPost, "/api/rooms/join", bytes.NewReader(body), headers)
+
+	var resp chat.JoinRoomResponse
+	if status == http.StatusCreated || status == http.StatusAccepted {
+		if err := json.Unmarshal(respBody, &resp); err != nil {
+			t.Fatalf("failed to unmarshal success resp: %v", err)
+		}
+	}
+
+	return status, resp
+}
+
+// setWaitlistTimeouts shortens the waitlist timeouts for the test
+func setWaitlistTimeouts(t *testing.T, timeout, pollTimeout time.Duration) {
+	defaultTimeout, defaultPollTimeout := config.WaitlistTimeout, config.WaitlistPollTimeout
+	config.WaitlistTimeout, config.WaitlistPollTimeout = timeout, pollTimeout
+	t.Cleanup(func() {
+		config.WaitlistTimeout, config.WaitlistPollTimeout = defaultTimeout, defaultPollTimeout
+	})
+}
+
+func Test_Waitlist_Disabled(t *testing.T) {
+	env := newJoinEnv(t)
+
+	joinRoom(t, true, 0, env.mux, "user", "http://kseli.app", env.invitetoken, "user")
+
+	_, errResp := joinRoom(t, false, http.StatusConflict, env.mux, "user2", "http://kseli.app", env.invitetoken, "user2")
+	if errResp.Message != "Chat Room is full." {
+		t.Fatalf("expected error message %q, got %q", "Chat Room is full.", errResp.Message)
+	}
+}
+
+func Test_Waitlist_QueueAndAdmit(t *testing.T) {
+	setWaitlistTimeouts(t, 5*time.Second, 100*time.Millisecond)
+	env := newWaitlistEnv(t)
+
+	// 1) Joiners to the full room get their place in the queue
+	for i, name := range []string{"waiter1", "waiter2"} {
+		status, resp := sendWaitlistJoin(t, env, name, name)
+		if status != http.StatusAccepted || !resp.Pending || resp.Position != i+1 {
+			t.Fatalf("expected 202 with position %d, got %d: %+v", i+1, status, resp)
+		}
+	}
+
+	// 2) Nothing frees up, the repeated request waits and returns the same place
+	status, resp := sendWaitlistJoin(t, env, "waiter2", "waiter2")
+	if status != http.StatusAccepted || resp.Position != 2 {
+		t.Fatalf("expected 202 with position 2, got %d: %+v", status, resp)
+	}
+
+	// 3) A kick frees a slot, the first waiter is admitted and gets a token
+	kickOrBanUser(t, true, 0, env.mux, 2, "kick", env.roomID, "http://kseli.app", env.adminToken)
+
+	status, resp = sendWaitlistJoin(t, env, "waiter1", "waiter1")
+	if status != http.StatusCreated || resp.Token == "" {
+		t.Fatalf("expected 201 with token, got %d: %+v", status, resp)
+	}
+
+	_, getResp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if len(getResp.Participants) != 2 {
+		t.Fatalf("expected 2 participants, got %d", len(getResp.Participants))
+	}
+
+	// 4) The second waiter moves up
+	status, resp = sendWaitlistJoin(t, env, "waiter2", "waiter2")
+	if status != http.StatusAccepted || resp.Position != 1 {
+		t.Fatalf("expected 202 with position 1, got %d: %+v", status, resp)
+	}
+}
+
+func Test_Waitlist_LongPollWakesUp(t *testing.T) {
+	setWaitlistTimeouts(t, 10*time.Second, 5*time.Second)
+	env := newWaitlistEnv(t)
+
+	sendWaitlistJoin(t, env, "waiter", "waiter")
+
+	type result struct {
+		status int
+		resp   chat.JoinRoomResponse
+	}
+	done := make(chan result, 1)
+
+	start := time.Now()
+	go func() {
+		status, resp := sendWaitlistJoin(t, env, "waiter", "waiter")
+		done <- result{status, resp}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	kickOrBanUser(t, true, 0, env.mux, 2, "ban", env.roomID, "http://kseli.app", env.adminToken)
+
+	select {
+	case res := <-done:
+		if res.status != http.StatusCreated || res.resp.Token == "" {
+			t.Fatalf("expected 201 with token, got %d: %+v", res.status, res.resp)
+		}
+		if time.Since(start) >= config.WaitlistPollTimeout {
+			t.Fatal("expected the waiting request to return as soon as the slot freed up")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the waiter to be admitted")
+	}
+}
+
+func Test_Waitlist_DroppedWithoutPolling(t *testing.T) {
+	setWaitlistTimeouts(t, 100*time.Millisecond, 50*time.Millisecond)
+	env := newWaitlistEnv(t)
+
+	sendWaitlistJoin(t, env, "gone", "gone")
+	time.Sleep(200 * time.Millisecond)
+
+	// the first waiter stopped asking, the next one is first in line
+	status, resp := sendWaitlistJoin(t, env, "waiter", "waiter")
+	if status != http.StatusAccepted || resp.Position != 1 {
+		t.Fatalf("expected 202 with position 1, got %d: %+v", status, resp)
+	}
+}
+
+func Test_Waitlist_UsernameTakenWhileWaiting(t *testing.T) {
+	setWaitlistTimeouts(t, 5*time.Second, 100*time.Millisecond)
+	env := newWaitlistEnv(t)
+
+	sendWaitlistJoin(t, env, "newname", "waiter")
+
+	// the participant takes the username of the waiter before leaving
+	renameUser(t, true, 0, env.mux, 1, "newname", env.roomID, "http://kseli.app", env.adminToken)
+	kickOrBanUser(t, true, 0, env.mux, 2, "kick", env.roomID, "http://kseli.app", env.adminToken)
+
+	status, _ := sendWaitlistJoin(t, env, "newname", "waiter")
+	if status != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", status)
+	}
+}
+
+func Test_Waitlist_RoomClosedWhileWaiting(t *testing.T) {
+	setWaitlistTimeouts(t, 10*time.Second, 5*time.Second)
+	env := newWaitlistEnv(t)
+
+	sendWaitlistJoin(t, env, "waiter", "waiter")
+
+	done := make(chan int, 1)
+	go func() {
+		status, _ := sendWaitlistJoin(t, env, "waiter", "waiter")
+		done <- status
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	deleteRoom(t, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+
+	select {
+	case status := <-done:
+		if status != http.StatusGone {
+			t.Fatalf("expected 410, got %d", status)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the waiting request to return")
+	}
+}
+
+func Test_Waitlist_Full(t *testing.T) {
+	setWaitlistTimeouts(t, 5*time.Second, 100*time.Millisecond)
+	env := newWaitlistEnv(t)
+
+	defaultMax := config.MaxWaitlistSize
+	config.MaxWaitlistSize = 1
+	defer func() { config.MaxWaitlistSize = defaultMax }()
+
+	sendWaitlistJoin(t, env, "waiter1", "waiter1")
+
+	status, _ := sendWaitlistJoin(t, env, "waiter2", "waiter2")
+	if status != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", status)
+	}
+}
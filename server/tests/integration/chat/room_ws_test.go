@@ -59,21 +59,9 @@ func Test_RoomWS_Success_JoinMsgReceived(t *testing.T) {
 	wsURL1 := "ws://" + env.serverAddr + "/ws/room?token=" + env.token
 	wsURL2 := "ws://" + env.serverAddr + "/ws/room?token=" + joinResp.Token
 
-	dialer := ws.Dialer{
-		Header: ws.HandshakeHeaderHTTP{
-			"Origin": []string{"http://kseli.app"},
-		},
-	}
-
-	conn1, _, _, err := dialer.Dial(context.Background(), wsURL1)
-	if err != nil {
-		t.Fatalf("ws handshake failed: %v", err)
-	}
+	conn1 := dialWS(t, wsURL1)
 
-	conn2, _, _, err := dialer.Dial(context.Background(), wsURL2)
-	if err != nil {
-		t.Fatalf("ws handshake failed: %v", err)
-	}
+	conn2 := dialWS(t, wsURL2)
 
 	// conn1: receive admin (self) join
 	msg := mustReadWSJoin(t, conn1)
@@ -97,21 +85,9 @@ func Test_RoomWS_Success_ChatMsgReceived(t *testing.T) {
 	wsURL1 := "ws://" + env.serverAddr + "/ws/room?token=" + env.token
 	wsURL2 := "ws://" + env.serverAddr + "/ws/room?token=" + joinResp.Token
 
-	dialer := ws.Dialer{
-		Header: ws.HandshakeHeaderHTTP{
-			"Origin": []string{"http://kseli.app"},
-		},
-	}
-
-	conn1, _, _, err := dialer.Dial(context.Background(), wsURL1)
-	if err != nil {
-		t.Fatalf("ws handshake failed: %v", err)
-	}
+	conn1 := dialWS(t, wsURL1)
 
-	conn2, _, _, err := dialer.Dial(context.Background(), wsURL2)
-	if err != nil {
-		t.Fatalf("ws handshake failed: %v", err)
-	}
+	conn2 := dialWS(t, wsURL2)
 
 	// drain join messages
 	mustReadWSJoin(t, conn1)
@@ -152,26 +128,11 @@ func Test_RoomWS_Success_LeaveMsgReceived(t *testing.T) {
 	wsURL2 := "ws://" + env.serverAddr + "/ws/room?token=" + joinResp1.Token
 	wsURL3 := "ws://" + env.serverAddr + "/ws/room?token=" + joinResp2.Token
 
-	dialer := ws.Dialer{
-		Header: ws.HandshakeHeaderHTTP{
-			"Origin": []string{"http://kseli.app"},
-		},
-	}
+	conn1 := dialWS(t, wsURL1)
 
-	conn1, _, _, err := dialer.Dial(context.Background(), wsURL1)
-	if err != nil {
-		t.Fatalf("ws handshake failed: %v", err)
-	}
+	conn2 := dialWS(t, wsURL2)
 
-	conn2, _, _, err := dialer.Dial(context.Background(), wsURL2)
-	if err != nil {
-		t.Fatalf("ws handshake failed: %v", err)
-	}
-
-	conn3, _, _, err := dialer.Dial(context.Background(), wsURL3)
-	if err != nil {
-		t.Fatalf("ws handshake failed: %v", err)
-	}
+	conn3 := dialWS(t, wsURL3)
 
 	// drain join messages
 	mustReadWSJoin(t, conn1)
@@ -181,7 +142,7 @@ func Test_RoomWS_Success_LeaveMsgReceived(t *testing.T) {
 	mustReadWSJoin(t, conn2)
 	mustReadWSJoin(t, conn3)
 
-	err = wsutil.WriteClientMessage(conn2, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "leave"))
+	err := wsutil.WriteClientMessage(conn2, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "leave"))
 	if err != nil {
 		t.Fatalf("user1 failed to send close: %v", err)
 	}
@@ -548,7 +509,7 @@ func mustReadWSJoin(t *testing.T, conn net.Conn) chat.JoinMsg {
 	return joinMsg
 }
 
-func assertJoinMsg(t *testing.T, msg chat.JoinMsg, wantID uint8, wantUser string, wantRole common.Role) {
+func assertJoinMsg(t *testing.T, msg chat.JoinMsg, wantID uint32, wantUser string, wantRole common.Role) {
 	t.Helper()
 	if msg.ID != wantID {
 		t.Errorf("Expected ID %d, got %d", wantID, msg.ID)
@@ -632,9 +593,38 @@ func mustReadWSLeave(t *testing.T, conn net.Conn) chat.LeaveMsg {
 	return leaveMsg
 }
 
-func assertLeaveMsg(t *testing.T, gotID, expectedID uint8) {
+func assertLeaveMsg(t *testing.T, gotID, expectedID uint32) {
 	t.Helper()
 	if gotID != expectedID {
 		t.Errorf("Expected leave ID %d, got %d", expectedID, gotID)
 	}
 }
+
+func mustReadWSType(t *testing.T, conn net.Conn, msgType string, data any) {
+	t.Helper()
+
+	raw, op, err := wsutil.ReadServerData(conn)
+	if err != nil {
+		t.Fatalf("ReadServerData failed: %v", err)
+	}
+	if op != ws.OpText {
+		t.Fatalf("Expected OpText, got %v", op)
+	}
+
+	var wsMsg chat.WSMsg
+	if err := json.Unmarshal(raw, &wsMsg); err != nil {
+		t.Fatalf("Unmarshal WSMsg failed: %v", err)
+	}
+	if wsMsg.MsgType != msgType {
+		t.Fatalf("Expected WSMsg type %q, got %q", msgType, wsMsg.MsgType)
+	}
+
+	jsonData, err := json.Marshal(wsMsg.Data)
+	if err != nil {
+		t.Fatalf("Marshal inner data failed: %v", err)
+	}
+
+	if err := json.Unmarshal(jsonData, data); err != nil {
+		t.Fatalf("Unmarshal %s data failed: %v", msgType, err)
+	}
+}
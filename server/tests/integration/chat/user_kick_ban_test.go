@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -12,7 +14,11 @@ import (
 	"kseli/auth"
 	"kseli/common"
 	"kseli/config"
+	"kseli/features/chat"
 	"kseli/router"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
 )
 
 func newKickBanEnv(t *testing.T) *getEnv {
@@ -160,7 +166,7 @@ func Test_KickAndBan_TokenValidation(t *testing.T) {
 }
 
 func Test_KickAndBan_BadRequests(t *testing.T) {
-	const maxBody = 128
+	const maxBody = 512
 
 	actions := []string{
 		"kick", "ban",
@@ -187,8 +193,8 @@ func Test_KickAndBan_BadRequests(t *testing.T) {
 			expectedErrMsg: "Invalid JSON request body.",
 		},
 		{
-			name:           "bad request - overflow uint8",
-			body:           []byte(`{"userId":555555}`),
+			name:           "bad request - overflow uint32",
+			body:           []byte(`{"userId":5555555555}`),
 			expectedStatus: http.StatusBadRequest,
 			expectedErrMsg: "Invalid JSON request body.",
 		},
@@ -199,7 +205,7 @@ func Test_KickAndBan_BadRequests(t *testing.T) {
 			expectedErrMsg: "Invalid JSON request body.",
 		},
 		{
-			name:           "bad request - float in uint8",
+			name:           "bad request - float in uint32",
 			body:           []byte(`{"userId":2.5}`),
 			expectedStatus: http.StatusBadRequest,
 			expectedErrMsg: "Invalid JSON request body.",
@@ -384,7 +390,7 @@ func Test_KickAndBan_NotAdmin(t *testing.T) {
 
 			errResp := kickOrBanUser(t, false, 403, env.mux, 2, action, env.roomID, "http://kseli.app", env.regularToken)
 
-			expectedErrMsg := fmt.Sprintf("You are not an admin and can't %s anyone from this room.", action)
+			expectedErrMsg := fmt.Sprintf("You don't have permission to %s anyone from this room.", action)
 			if errResp.Message != expectedErrMsg {
 				t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
 			}
@@ -422,7 +428,7 @@ func Test_KickAndBan_UserNotFound(t *testing.T) {
 		t.Run(tcName, func(t *testing.T) {
 			env := newKickBanEnv(t)
 
-			var userID uint8 = 3
+			var userID uint32 = 3
 			errResp := kickOrBanUser(t, false, 404, env.mux, userID, action, env.roomID, "http://kseli.app", env.adminToken)
 
 			expectedErrMsg := fmt.Sprintf("Participant with ID '%d' not found in room", userID)
@@ -432,3 +438,256 @@ func Test_KickAndBan_UserNotFound(t *testing.T) {
 		})
 	}
 }
+
+func Test_Bans_List(t *testing.T) {
+	env := newKickBanEnv(t)
+
+	resp, _ := getBans(t, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if len(resp.Bans) != 0 {
+		t.Fatalf("expected no bans, got %d", len(resp.Bans))
+	}
+
+	bannedAt := time.Now().Unix()
+	kickOrBanUser(t, true, 0, env.mux, 2, "ban", env.roomID, "http://kseli.app", env.adminToken)
+
+	resp, _ = getBans(t, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if len(resp.Bans) != 1 {
+		t.Fatalf("expected 1 ban, got %d", len(resp.Bans))
+	}
+
+	ban := resp.Bans[0]
+	if ban.ID != 2 || ban.Username != "user" {
+		t.Fatalf("expected ban of user 2 'user', got %+v", ban)
+	}
+	if ban.BannedAt < bannedAt {
+		t.Fatalf("expected bannedAt >= %d, got %d", bannedAt, ban.BannedAt)
+	}
+}
+
+func Test_Unban_Success(t *testing.T) {
+	env := newKickBanEnv(t)
+
+	_, getResp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	inviteToken := strings.Split(getResp.InviteLink, "#invite=")[1]
+
+	// 1) Ban the user and make sure they can't join back
+	kickOrBanUser(t, true, 0, env.mux, 2, "ban", env.roomID, "http://kseli.app", env.adminToken)
+	joinRoom(t, false, http.StatusForbidden, env.mux, "user", "http://kseli.app", inviteToken, "user")
+
+	// 2) Lift the ban and join again
+	unbanUser(t, true, 0, env.mux, "2", env.roomID, "http://kseli.app", env.adminToken)
+
+	resp, _ := getBans(t, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if len(resp.Bans) != 0 {
+		t.Fatalf("expected no bans, got %d", len(resp.Bans))
+	}
+
+	joinRoom(t, true, 0, env.mux, "user", "http://kseli.app", inviteToken, "user")
+}
+
+func Test_Unban_BadRequests(t *testing.T) {
+	env := newKickBanEnv(t)
+
+	kickOrBanUser(t, true, 0, env.mux, 2, "ban", env.roomID, "http://kseli.app", env.adminToken)
+
+	type testCase struct {
+		name           string
+		userID         string
+		expectedStatus int
+		expectedErrMsg string
+	}
+
+	tests := []testCase{
+		{
+			name:           "userId zero",
+			userID:         "0",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: "Invalid User Id.",
+		},
+		{
+			name:           "userId not a number",
+			userID:         "abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: "Invalid User Id.",
+		},
+		{
+			name:           "userId overflow uint32",
+			userID:         "5555555555",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: "Invalid User Id.",
+		},
+		{
+			name:           "user not banned",
+			userID:         "3",
+			expectedStatus: http.StatusNotFound,
+			expectedErrMsg: "Banned participant with ID '3' not found in room",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			errResp := unbanUser(t, false, tc.expectedStatus, env.mux, tc.userID, env.roomID, "http://kseli.app", env.adminToken)
+
+			if errResp.Message != tc.expectedErrMsg {
+				t.Fatalf("[%s] expected error message %q, got %q", tc.name, tc.expectedErrMsg, errResp.Message)
+			}
+		})
+	}
+}
+
+func Test_Bans_NotAllowed(t *testing.T) {
+	env := newKickBanEnv(t)
+
+	_, errResp := getBans(t, false, http.StatusForbidden, env.mux, env.roomID, "http://kseli.app", env.regularToken)
+
+	expectedErrMsg := "You don't have permission to see the bans of this room."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+
+	errResp = unbanUser(t, false, http.StatusForbidden, env.mux, "1", env.roomID, "http://kseli.app", env.regularToken)
+
+	expectedErrMsg = "You don't have permission to unban anyone from this room."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_Ban_Timed(t *testing.T) {
+	env := newKickBanEnv(t)
+
+	_, getResp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	inviteToken := strings.Split(getResp.InviteLink, "#invite=")[1]
+
+	// 1) Ban the user for 5 minutes
+	banBody, _ := json.Marshal(chat.UserRequest{
+		TargetUserID:    2,
+		DurationMinutes: 5,
+	})
+	banHeaders := map[string]string{
+		"Origin":        "http://kseli.app",
+		"Authorization": env.adminToken,
+	}
+
+	status, respBody := sendRequest(env.mux, http.MethodPost, "/api/rooms/"+env.roomID+"/ban", bytes.NewReader(banBody), banHeaders)
+	if status != http.StatusNoContent {
+		t.Fatalf("Ban: expected 204, got %d, body: %s", status, string(respBody))
+	}
+
+	resp, _ := getBans(t, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	if len(resp.Bans) != 1 {
+		t.Fatalf("expected 1 ban, got %d", len(resp.Bans))
+	}
+	if expected := resp.Bans[0].BannedAt + 5*60; resp.Bans[0].ExpiresAt != expected {
+		t.Fatalf("expected ban to expire at %d, got %d", expected, resp.Bans[0].ExpiresAt)
+	}
+
+	// 2) Joining back reports how long the ban still lasts
+	joinBody, _ := json.Marshal(chat.JoinRoomRequest{
+		Username: "user",
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/rooms/join", bytes.NewReader(joinBody))
+	req.Header.Set("Origin", "http://kseli.app")
+	req.Header.Set("Authorization", inviteToken)
+	req.Header.Set("X-Participant-Session-Id", "user")
+	rr := httptest.NewRecorder()
+	env.mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Join: expected 403, got %d, body: %s", rr.Code, rr.Body.String())
+	}
+
+	retryAfter, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	if err != nil || retryAfter <= 0 || retryAfter > 5*60 {
+		t.Fatalf("expected Retry-After within the ban duration, got %q", rr.Header().Get("Retry-After"))
+	}
+
+	var errResp common.ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &errResp); err != nil {
+		t.Fatalf("failed to unmarshal error resp: %v", err)
+	}
+
+	expectedErrMsg := fmt.Sprintf("You are banned from this room, try again in %d seconds.", retryAfter)
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_KickAndBan_Reason(t *testing.T) {
+	env := newKickBanEnv(t)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	// drain join messages, the admin join is read before the user connects so only the admin gets it
+	conn1 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	conn2 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.regularToken)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+
+	kickBody, _ := json.Marshal(chat.UserRequest{
+		TargetUserID: 2,
+		Reason:       "spamming links",
+	})
+	kickHeaders := map[string]string{
+		"Origin":        "http://kseli.app",
+		"Authorization": env.adminToken,
+	}
+
+	status, respBody := sendRequest(env.mux, http.MethodPost, "/api/rooms/"+env.roomID+"/kick", bytes.NewReader(kickBody), kickHeaders)
+	if status != http.StatusNoContent {
+		t.Fatalf("Kick: expected 204, got %d, body: %s", status, string(respBody))
+	}
+
+	// the kicked participant gets the reason before the close frame
+	var removed chat.RemovedMsg
+	mustReadWSType(t, conn2, "removed", &removed)
+	if removed.Action != "kick" || removed.Reason != "spamming links" {
+		t.Fatalf("unexpected removed message %+v", removed)
+	}
+
+	_, op, err := wsutil.ReadServerData(conn2)
+	if err == nil || op == ws.OpText {
+		t.Fatalf("expected the connection to be closed, got op %v, err %v", op, err)
+	}
+
+	var moderation chat.ModerationMsg
+	mustReadWSType(t, conn1, "moderation", &moderation)
+	if moderation.ID != 2 || moderation.Username != "user" || moderation.Action != "kick" || moderation.Reason != "spamming links" {
+		t.Fatalf("unexpected moderation message %+v", moderation)
+	}
+
+	assertLeaveMsg(t, mustReadWSLeave(t, conn1).ID, 2)
+}
+
+func Test_KickAndBan_ReasonTooLong(t *testing.T) {
+	env := newKickBanEnv(t)
+
+	body, _ := json.Marshal(chat.UserRequest{
+		TargetUserID: 2,
+		Reason:       strings.Repeat("a", 101),
+	})
+	headers := map[string]string{
+		"Origin":        "http://kseli.app",
+		"Authorization": env.adminToken,
+	}
+
+	status, respBody := sendRequest(env.mux, http.MethodPost, "/api/rooms/"+env.roomID+"/ban", bytes.NewReader(body), headers)
+	if status != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d, body: %s", status, string(respBody))
+	}
+
+	var errResp common.ErrorResponse
+	if err := json.Unmarshal(respBody, &errResp); err != nil {
+		t.Fatalf("failed to unmarshal error resp: %v", err)
+	}
+
+	expectedErrMsg := "Reason can be at most 100 characters."
+	if errResp.FieldErrors["reason"] != expectedErrMsg {
+		t.Fatalf("expected reason field error %q, got %q", expectedErrMsg, errResp.FieldErrors["reason"])
+	}
+}
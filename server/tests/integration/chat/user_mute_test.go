@@ -0,0 +1,126 @@
+package chat_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kseli/features/chat"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+func Test_Mute_Success(t *testing.T) {
+	env := newGetEnv(t)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	// drain join messages, the admin join is read before the user connects so only the admin gets it
+	conn1 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	conn2 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.regularToken)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+
+	// 1) mute the user for 5 minutes
+	headers := map[string]string{
+		"Origin":        "http://kseli.app",
+		"Authorization": env.adminToken,
+	}
+	body, _ := json.Marshal(chat.UserRequest{
+		TargetUserID:    2,
+		DurationMinutes: 5,
+	})
+
+	status, respBody := sendRequest(env.mux, http.MethodPost, "/api/rooms/"+env.roomID+"/mute", bytes.NewReader(body), headers)
+	if status != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d, body: %s", status, string(respBody))
+	}
+
+	var mute chat.MuteMsg
+	mustReadWSType(t, conn1, "muted", &mute)
+	if mute.ID != 2 || mute.MutedUntil == 0 {
+		t.Fatalf("expected participant 2 to be muted for a while, got %+v", mute)
+	}
+	mustReadWSType(t, conn2, "muted", &mute)
+
+	_, resp, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	for _, p := range resp.Participants {
+		if p.Muted != (p.ID == 2) {
+			t.Fatalf("unexpected muted flag for participant %d: %v", p.ID, p.Muted)
+		}
+	}
+
+	// 2) the muted user's message is dropped and they get a notice instead
+	if err := wsutil.WriteClientText(conn2, []byte("can anyone hear me")); err != nil {
+		t.Fatalf("user failed to send message: %v", err)
+	}
+	mustReadWSType(t, conn2, "muted", &mute)
+	if mute.ID != 2 {
+		t.Fatalf("expected a muted notice for participant 2, got %+v", mute)
+	}
+
+	// clients using the command envelope get the reason in the reply to the command instead
+	mustSendWSText(t, conn2, `{"type":"msg","id":3,"data":{"content":"can anyone hear me"}}`)
+
+	var errMsg chat.ErrorMsg
+	mustReadWSType(t, conn2, "error", &errMsg)
+	if errMsg.ID != 3 || !strings.HasPrefix(errMsg.Message, "You are muted, try again in") {
+		t.Fatalf("expected a muted error for command 3, got %+v", errMsg)
+	}
+
+	// 3) after unmute the messages are relayed again
+	kickOrBanUser(t, true, 0, env.mux, 2, "unmute", env.roomID, "http://kseli.app", env.adminToken)
+
+	mustReadWSType(t, conn1, "unmuted", &mute)
+	mustReadWSType(t, conn2, "unmuted", &mute)
+	if mute.ID != 2 {
+		t.Fatalf("expected participant 2 to be unmuted, got %+v", mute)
+	}
+
+	if err := wsutil.WriteClientText(conn2, []byte("back again")); err != nil {
+		t.Fatalf("user failed to send message: %v", err)
+	}
+
+	// the admin's first chat message must be the one sent after the unmute
+	assertChatMsg(t, mustReadWSChat(t, conn1), "user", "back again")
+}
+
+func Test_Mute_NotAllowed(t *testing.T) {
+	env := newGetEnv(t)
+
+	errResp := kickOrBanUser(t, false, http.StatusForbidden, env.mux, 1, "mute", env.roomID, "http://kseli.app", env.regularToken)
+
+	expectedErrMsg := "You don't have permission to mute anyone in this room."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_Mute_Self(t *testing.T) {
+	env := newGetEnv(t)
+
+	errResp := kickOrBanUser(t, false, http.StatusBadRequest, env.mux, 1, "mute", env.roomID, "http://kseli.app", env.adminToken)
+
+	expectedErrMsg := "You can't mute yourself."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_Unmute_NotMuted(t *testing.T) {
+	env := newGetEnv(t)
+
+	errResp := kickOrBanUser(t, false, http.StatusNotFound, env.mux, 2, "unmute", env.roomID, "http://kseli.app", env.adminToken)
+
+	expectedErrMsg := "Muted participant with ID '2' not found in room"
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
@@ -0,0 +1,110 @@
+package chat_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kseli/auth"
+	"kseli/features/chat"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+func Test_Rename_Self(t *testing.T) {
+	env := newGetEnv(t)
+
+	resp, _ := renameUser(t, true, 0, env.mux, 0, "newname", env.roomID, "http://kseli.app", env.regularToken)
+
+	claims, err := auth.ValidateToken[auth.Claims](resp.Token)
+	if err != nil {
+		t.Fatalf("expected a valid reissued token, got error: %v", err)
+	}
+	if claims.Username != "newname" || claims.UserID != 2 {
+		t.Fatalf("expected token for participant 2 named %q, got %+v", "newname", claims)
+	}
+
+	_, room, _ := getRoom(t, true, true, 0, env.mux, env.roomID, "http://kseli.app", env.adminToken)
+	for _, p := range room.Participants {
+		if p.ID == 2 && p.Username != "newname" {
+			t.Fatalf("expected participant 2 to be renamed, got %q", p.Username)
+		}
+	}
+}
+
+func Test_Rename_SameUsername(t *testing.T) {
+	env := newGetEnv(t)
+
+	// nothing changes, the current token stays valid
+	resp, _ := renameUser(t, true, 0, env.mux, 0, "user", env.roomID, "http://kseli.app", env.regularToken)
+	if resp.Token != "" {
+		t.Fatalf("expected no new token, got %q", resp.Token)
+	}
+
+	renameUser(t, true, 0, env.mux, 0, "renamed", env.roomID, "http://kseli.app", env.regularToken)
+}
+
+func Test_Rename_Errors(t *testing.T) {
+	env := newGetEnv(t)
+
+	_, errResp := renameUser(t, false, http.StatusBadRequest, env.mux, 0, "admin", env.roomID, "http://kseli.app", env.regularToken)
+	if errResp.FieldErrors["username"] != "This username is taken." {
+		t.Fatalf("expected username taken field error, got %+v", errResp)
+	}
+
+	_, errResp = renameUser(t, false, http.StatusBadRequest, env.mux, 0, "no", env.roomID, "http://kseli.app", env.regularToken)
+	if errResp.FieldErrors["username"] != "Username must be between 3 and 15 characters." {
+		t.Fatalf("expected username length field error, got %+v", errResp)
+	}
+
+	_, errResp = renameUser(t, false, http.StatusForbidden, env.mux, 1, "boss", env.roomID, "http://kseli.app", env.regularToken)
+
+	expectedErrMsg := "You don't have permission to rename other participants."
+	if errResp.Message != expectedErrMsg {
+		t.Fatalf("expected error message %q, got %q", expectedErrMsg, errResp.Message)
+	}
+}
+
+func Test_Rename_ForcedWSCommand(t *testing.T) {
+	env := newGetEnv(t)
+
+	server := httptest.NewServer(env.mux)
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	// drain join messages, the admin join is read before the user connects so only the admin gets it
+	conn1 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.adminToken)
+	mustReadWSJoin(t, conn1)
+
+	conn2 := dialWS(t, "ws://"+serverAddr+"/ws/room?token="+env.regularToken)
+	mustReadWSJoin(t, conn1)
+	mustReadWSJoin(t, conn2)
+
+	if err := wsutil.WriteClientText(conn1, []byte(`{"type":"rename","data":{"userId":2,"username":"polite"}}`)); err != nil {
+		t.Fatalf("admin failed to send command: %v", err)
+	}
+
+	var token chat.TokenMsg
+	mustReadWSType(t, conn2, "token", &token)
+
+	claims, err := auth.ValidateToken[auth.Claims](token.Token)
+	if err != nil {
+		t.Fatalf("expected a valid reissued token, got error: %v", err)
+	}
+	if claims.Username != "polite" {
+		t.Fatalf("expected reissued token with username %q, got %q", "polite", claims.Username)
+	}
+
+	var renamed chat.RenameMsg
+	mustReadWSType(t, conn1, "renamed", &renamed)
+	if renamed.ID != 2 || renamed.Username != "polite" {
+		t.Fatalf("expected participant 2 to be renamed to %q, got %+v", "polite", renamed)
+	}
+	mustReadWSType(t, conn2, "renamed", &renamed)
+
+	// the connection opened with the old token keeps working and uses the new name
+	if err := wsutil.WriteClientText(conn2, []byte("hello")); err != nil {
+		t.Fatalf("user failed to send message: %v", err)
+	}
+	assertChatMsg(t, mustReadWSChat(t, conn1), "polite", "hello")
+}